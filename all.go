@@ -5,31 +5,40 @@ All Hands On Deck (aka "all") is a simple agentless orchestration system written
 in Go, for Linux. You can run it *from* any platform that supports Go (Macs are
 popular, I hear). Commands are executed in parallelish, as are workflows (commands
 within a workflow are executed serially)
+
+This is the command-line interface. The orchestration itself is in the deck package,
+for embedding in other tools.
 */
 package main
 
 import (
 	"github.com/cheggaaa/pb/v3"
-	"github.com/cognusion/semaphore"
+	"github.com/cognusion/AllHandsOnDeck/deck"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"os/user"
-	"runtime"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/pflag"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run does everything, returning the exit code. See Summary for what it means.
+func run() int {
 
 	var (
 		sshAgent     bool
@@ -39,6 +48,8 @@ func main() {
 		sudo         bool
 		timeout      int
 		cmd          string
+		put          string
+		get          string
 		workflow     string
 		filter       string
 		configTest   bool
@@ -48,33 +59,46 @@ func main() {
 		listFlows    bool
 		debug        bool
 		wave         int
+		allWaves     bool
+		wavePauseStr string
 		max          int
 		format       string
 		logFile      string
 		errorLogFile string
 		debugLogFile string
 		progressBar  bool
+		stream       bool
+		color        bool
+		group        string
+		outDir       string
+		junitFile    string
+		templateFile string
+		htmlFile     string
 		dryrun       bool
 		sleepStr     string
+		cmdTimeout   time.Duration
 		awsHosts     bool
 		awsRegions   string
 		cliVars      string
 		dnf          bool
-
-		conf     Config
+		hostKeys     string
+		knownHosts   string
+		batchStr     string
+		stateFile    string
+		retryFailed  string
+		resume       bool
+		maxFailStr   string
+
+		r        *deck.Runner
 		auths    []ssh.AuthMethod
+		hkc      *deck.HostKeyChecker
 		wfIndex  int
 		sleepFor time.Duration
-		wg       sync.WaitGroup
 	)
 
 	// Grab the current username, best we can
 	currentUser, _ := user.Current()
 
-	// Channels for command and workflow -results
-	commandResults := make(chan CommandReturn, 100)
-	wfResults := make(chan WorkflowReturn, 100)
-
 	pflag.BoolVar(&sshAgent, "sshagent", false, "Connect and use SSH-Agent vs. user key")
 	pflag.StringVar(&sshKey, "sshkey", currentUser.HomeDir+"/.ssh/id_rsa", "If not using the SSH-Agent, where to grab the key")
 	pflag.BoolVar(&debug, "debug", false, "Enable Debug output")
@@ -87,23 +111,52 @@ func main() {
 	pflag.BoolVar(&quiet, "quiet", false, "Suppress most-if-not-all normal output")
 	pflag.BoolVar(&configDump, "configdump", false, "Load and parse configs, dump them to output and exit")
 	pflag.StringVar(&cmd, "cmd", "", "The command to run")
+	pflag.StringVar(&put, "put", "", "Copy a local file to hosts: 'local remote [mode]'")
+	pflag.StringVar(&get, "get", "", "Copy a file from hosts into per-host folders: 'remote localdir'")
 	pflag.StringVar(&filter, "filter", "", "Boolean expression to positively filter on host elements (Tags, Name, Address, Arch, User, Port, etc.)")
 	pflag.BoolVar(&listHosts, "listhosts", false, "List the hostnames and addresses and exit")
 	pflag.BoolVar(&listFlows, "listworkflows", false, "List the workflows and exit")
 	pflag.IntVar(&wave, "wave", 0, "Specify which \"wave\" this should be applied to")
+	pflag.StringVar(&batchStr, "batch", "", "Run hosts in rolling batches of this many, or this percent (e.g. 10 or 10%), each after the last completes")
+	pflag.StringVar(&maxFailStr, "max-fail", "", "Abort remaining batches when more than this many, or this percent, of hosts have failed")
+	pflag.StringVar(&stateFile, "statefile", currentUser.HomeDir+"/.all/state.json", "Where to write the state of every host at the end of the run, for --retry-failed. Empty to not")
+	pflag.StringVar(&retryFailed, "retry-failed", "", "Run again on only the hosts that didn't succeed in this state file, doing what was done then, unless told otherwise")
+	pflag.BoolVar(&resume, "resume", false, "With --retry-failed, resume each workflow at the command it broke on, instead of the beginning")
+	pflag.BoolVar(&allWaves, "waves", false, "Run every wave, in ascending order, halting if a wave has failures")
+	pflag.StringVar(&wavePauseStr, "wavepause", "", "With --waves, pause this long between waves (e.g. 5m), or 'confirm' to ask first")
 	pflag.IntVar(&max, "max", 0, "Specify the maximum number of concurrent commands to execute. Set to 0 to make a good guess for you (default 0)")
-	pflag.StringVar(&format, "format", "text", "Output format. One of: text, json, or xml")
+	pflag.StringVar(&format, "format", "text", "Output format. One of: text, json, xml, jsonl, csv, or template")
+	pflag.StringVar(&templateFile, "template", "", "With --format template, the Go text/template file to output the run with")
 	pflag.StringVar(&logFile, "logfile", "", "Output to a logfile, instead of standard out (enables progressbar to screen)")
+	pflag.StringVar(&outDir, "outdir", "", "Also write each host's results into this folder: <host>/<step>.stdout and .stderr for each command, and <host>/meta.json")
+	pflag.StringVar(&htmlFile, "html", "", "Also write a static HTML report of the run to this file, to attach to a ticket")
+	pflag.StringVar(&junitFile, "junit", "", "Also write a JUnit XML report to this file: each host is a test suite, and each command (or workflow step) a test case")
 	pflag.StringVar(&errorLogFile, "errorlogfile", "", "Output errors to a logfile, instead of standard error")
 	pflag.StringVar(&debugLogFile, "debuglogfile", "", "Output debugs to a logfile, instead of standard error")
 	pflag.BoolVar(&progressBar, "bar", true, "If outputting to a logfile, display a progress bar")
+	pflag.BoolVar(&stream, "stream", false, "Output every line as it arrives, prefixed by the host's name. Results still go to --logfile, if set")
+	pflag.BoolVar(&color, "color", false, "With --stream, color each host's name")
+	pflag.StringVar(&group, "group", "", "Output each distinct result once, with the hosts that had it, instead of per host. 'hosts' (the default) lists them, 'count' counts them")
+	pflag.Lookup("group").NoOptDefVal = "hosts"
 	pflag.BoolVar(&dryrun, "dryrun", false, "If you want to go through the motions, but never actually SSH to anything")
 	pflag.StringVar(&sleepStr, "sleep", "0ms", "Duration to sleep between host iterations (e.g. 32ms or 1s)")
+	pflag.DurationVar(&cmdTimeout, "cmdtimeout", 0, "Duration before each command times out, and is terminated (e.g. 30s or 10m). Workflows may override this")
 	pflag.BoolVar(&awsHosts, "awshosts", false, "Get EC2 hosts and tags from AWS API")
 	pflag.StringVar(&awsRegions, "awsregions", "", "Comma-delimited list of AWS Regions to check if --awshosts is set")
 	pflag.StringVar(&cliVars, "vars", "", "Comma-delimited list of variables to pass in for use in workflows, sometimes")
 	pflag.BoolVar(&dnf, "dnf", false, "Use dnf instead of yum for some commands")
-	pflag.Parse()
+	pflag.StringVar(&hostKeys, "hostkeys", deck.HostKeyStrict, "Host key checking. One of: strict, tofu (trust and record unknown keys), or off")
+	pflag.StringVar(&knownHosts, "knownhosts", "", "An additional known_hosts file to check host keys against")
+
+	// Bad flags are fatal, not failures
+	pflag.CommandLine.Init(os.Args[0], pflag.ContinueOnError)
+	if err := pflag.CommandLine.Parse(os.Args[1:]); err == pflag.ErrHelp {
+		return deck.ExitOK
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		pflag.Usage()
+		return deck.ExitFatal
+	}
 
 	/*
 	 * Initially handle Logging: debug, error, and "standard"
@@ -128,76 +181,105 @@ func main() {
 	} else {
 		// Load the conf object from the config
 		// files in the configFolder
-		conf = loadConfigs(configFolder)
+		Debug.Printf("Looking for configs in '%s'\n", configFolder)
+		conf, err := deck.LoadConfigs(configFolder)
+		if err != nil {
+			log.Fatalf("Error loading configs: %s\n", err)
+		}
+		if err := conf.Check(); err != nil {
+			log.Fatalf("Error in configs: %s\n", err)
+		}
+		if _, err := deck.ParseFilter(filter); err != nil {
+			log.Fatalf("Error in --filter: %s\n", err)
+		}
 
-		// Build any needed global vars
-		GlobalVars = miscToMap(conf.Miscs)
+		// The Runner builds any needed global vars
+		r = deck.NewRunner(conf)
 	}
 
 	if listFlows {
 		// List all the configured workflows, and exit
-		for _, flow := range conf.Workflows {
+		for _, flow := range r.Config.Workflows {
 			if debug {
-				flow.Init()
+				flow.InitVars(r.Vars)
 				fmt.Printf("%s\n%#v\n\n", flow.Name, flow)
 			} else {
 				fmt.Printf("%s\n", flow.Name)
 			}
 		}
-		os.Exit(0)
+		return deck.ExitOK
 	}
 
 	/*
 	 * Any "miscs" config stuff here
 	 *
 	 */
-	if _, ok := GlobalVars["usesshagent"]; ok && GlobalVars["usesshagent"] == "true" {
+	if _, ok := r.Vars["usesshagent"]; ok && r.Vars["usesshagent"] == "true" {
 		sshAgent = true
 	}
 
-	if _, ok := GlobalVars["maxexecs"]; ok {
-		m, err := strconv.Atoi(GlobalVars["maxexecs"])
+	if _, ok := r.Vars["maxexecs"]; ok {
+		m, err := strconv.Atoi(r.Vars["maxexecs"])
 		if err != nil {
-			log.Fatalf("maxexecs set to '%s', and cannot convert to number: %s\n", GlobalVars["maxexecs"], err.Error())
+			log.Fatalf("maxexecs set to '%s', and cannot convert to number: %s\n", r.Vars["maxexecs"], err.Error())
 		}
 		max = m
 	}
 
-	if f, ok := GlobalVars["outputformat"]; ok {
+	if f, ok := r.Vars["outputformat"]; ok {
 		if logFile != "STDOUT" {
 			format = f
 		}
 	}
 
-	if l, ok := GlobalVars["outputlog"]; ok {
+	if t, ok := r.Vars["outputtemplate"]; ok && templateFile == "" {
+		templateFile = t
+	}
+
+	if l, ok := r.Vars["outputlog"]; ok {
 		if logFile != "STDOUT" {
 			logFile = l
 			SetLog(l)
 		}
 	}
 
-	if l, ok := GlobalVars["erroroutputlog"]; ok {
+	if d, ok := r.Vars["outputdir"]; ok && outDir == "" {
+		outDir = d
+	}
+
+	if l, ok := r.Vars["erroroutputlog"]; ok {
 		errorLogFile = l
 		SetError(l)
 	}
 
-	if l, ok := GlobalVars["debugoutputlog"]; ok {
+	if l, ok := r.Vars["debugoutputlog"]; ok {
 		debugLogFile = l
 		SetDebug(l)
 	}
 
-	if _, ok := GlobalVars["useawshosts"]; ok && GlobalVars["useawshosts"] == "true" {
+	if _, ok := r.Vars["useawshosts"]; ok && r.Vars["useawshosts"] == "true" {
 		awsHosts = true
 	}
 
-	if r, ok := GlobalVars["awsregions"]; ok && awsRegions == "" {
-		awsRegions = r
+	if a, ok := r.Vars["awsregions"]; ok && awsRegions == "" {
+		awsRegions = a
 	}
 
-	if _, ok := GlobalVars["usednf"]; ok && GlobalVars["usednf"] == "true" {
+	if _, ok := r.Vars["usednf"]; ok && r.Vars["usednf"] == "true" {
 		dnf = true
 	}
 
+	if h, ok := r.Vars["hostkeychecking"]; ok && !pflag.Lookup("hostkeys").Changed {
+		hostKeys = h
+	}
+
+	if k, ok := r.Vars["knownhostsfile"]; ok && knownHosts == "" {
+		knownHosts = k
+	}
+
+	// The loggers are settled
+	r.Log, r.Error, r.Debug = Log, Error, Debug
+
 	/*
 	 * Are we dealing with AWS hosts/tags/regions?
 	 */
@@ -206,59 +288,38 @@ func main() {
 		if awsRegions != "" {
 			// CLI
 			regions = strings.Split(awsRegions, ",")
-		} else if r, ok := GlobalVars["aws_regions"]; ok {
+		} else if a, ok := r.Vars["aws_regions"]; ok {
 			// Misc
-			regions = strings.Split(r, ",")
+			regions = strings.Split(a, ",")
 		} else {
 			// Grab default
-			regions = append(regions, getAwsRegion())
+			regions = append(regions, deck.AWSRegion())
 		}
 
 		// Grab the keys from the environment
 		var aKey, sKey string
 
 		// Access key
-		if k, ok := GlobalVars["awsaccess_key"]; ok {
+		if k, ok := r.Vars["awsaccess_key"]; ok {
 			aKey = k
 		} else {
 			aKey = os.Getenv("AWS_ACCESS_KEY_ID")
 		}
 
 		// Secret key
-		if k, ok := GlobalVars["awsaccess_secretkey"]; ok {
+		if k, ok := r.Vars["awsaccess_secretkey"]; ok {
 			sKey = k
 		} else {
 			sKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
 		}
 
-		var awsconf Config
-		for _, region := range regions {
-			session := initAWS(region, aKey, sKey)
-			resp, err := getEc2Instances(session)
-
-			if err != nil {
-				// Print the error, cast err to awserr.Error to get the Code and
-				// Message from an error.
-				fmt.Println(err.Error())
-
-			} else {
-				for idx := range resp.Reservations {
-					for _, inst := range resp.Reservations[idx].Instances {
-						if inst.PrivateIpAddress == nil {
-							// Stopped, terminated, whatevs.
-							continue
-						}
-						if inst.Platform == nil || *inst.Platform != "windows" {
-							// Not Windows, phew
-							awsconf.AddHost(newHostFromInstance(inst))
-						}
-					}
-				}
-			}
-			// grab all the hosts
-			// populate the Config
+		// grab all the hosts, and populate the Config
+		awsconf, err := deck.AWSHosts(regions, aKey, sKey)
+		if err != nil {
+			// Print the error, and carry on with whatever regions worked
+			fmt.Println(err.Error())
 		}
-		conf.Merge(awsconf)
+		r.Config.Merge(awsconf)
 	}
 
 	/*
@@ -266,22 +327,8 @@ func main() {
 	 * and cmd is a list,
 	 * we handle that here
 	 */
-	if workflow != "" && strings.Contains(workflow, ",") {
-		newFlow := Workflow{
-			Name:      workflow,
-			MustChain: false,
-		}
-
-		for _, c := range strings.Split(workflow, ",") {
-			wfi := conf.WorkflowIndex(c)
-			if wfi < 0 {
-				log.Fatalf("Workflow '%s' in chain does not exist in specified configs!\n", c)
-			}
-			newFlow.Merge(&conf.Workflows[wfi])
-		}
-
-		conf.Workflows = append(conf.Workflows, newFlow)
-
+	if err := r.Config.ChainWorkflows(workflow); err != nil {
+		log.Fatalf("%s!\n", err)
 	}
 
 	/*
@@ -291,26 +338,81 @@ func main() {
 	 */
 	if configDump {
 		// Dump the config
-		fmt.Println(dumpConfigs(conf))
-		os.Exit(0)
+		fmt.Println(r.Config.Dump())
+		return deck.ExitOK
 	} else if configTest {
 		// Just kicking the tires...
 		fmt.Println("Config loaded and bootstrapped successfully...")
-		os.Exit(0)
+		return deck.ExitOK
 	} else if listHosts {
 		// List all the configured hosts, applying filtering logic, and exit
 		if workflow != "" {
-			wfIndex = conf.WorkflowIndex(workflow)
+			wfIndex = r.Config.WorkflowIndex(workflow)
 		} else {
 			wfIndex = -1
 		}
 
-		filteredHosts := conf.FilteredHostList(filter, wave, wfIndex)
+		filteredHosts := r.Config.FilteredHostList(filter, wave, wfIndex)
 
 		for _, host := range filteredHosts {
 			fmt.Printf("%s: %s\n", host.Name, host.Address)
 		}
-		os.Exit(0)
+		return deck.ExitOK
+	}
+
+	/*
+	 * Retrying the hosts that didn't succeed last time
+	 */
+	var retryWorkflow string
+	if retryFailed != "" {
+		state, err := deck.LoadRunState(retryFailed)
+		if err != nil {
+			log.Fatalf("Error loading --retry-failed: %s\n", err)
+		}
+		r.Retry = state.Failed()
+		retryWorkflow = state.Workflow
+		if len(r.Retry) == 0 {
+			Log.Printf("Every host succeeded in '%s', so there's nothing to retry\n", retryFailed)
+			return deck.ExitOK
+		}
+
+		// Do what we did, unless told otherwise
+		if cmd == "" && workflow == "" && put == "" && get == "" {
+			switch {
+			case strings.HasPrefix(state.Workflow, "PUT "):
+				put = strings.TrimPrefix(state.Workflow, "PUT ")
+			case strings.HasPrefix(state.Workflow, "GET "):
+				get = strings.TrimPrefix(state.Workflow, "GET ")
+			case state.Workflow != "":
+				workflow = state.Workflow
+				if err := r.Config.ChainWorkflows(workflow); err != nil {
+					log.Fatalf("%s!\n", err)
+				}
+			default:
+				cmd = state.Command
+			}
+		}
+	}
+
+	/*
+	 * PUT and GET are just tiny workflows
+	 */
+	if put != "" || get != "" {
+		if cmd != "" || workflow != "" || (put != "" && get != "") {
+			log.Fatalln("--cmd, --workflow, --put, and --get are mutually exclusive!")
+		}
+
+		newFlow := deck.Workflow{
+			Name:     "PUT " + put,
+			Commands: []string{"PUT " + put},
+		}
+		if get != "" {
+			newFlow.Name = "GET " + get
+			newFlow.Commands = []string{"GET " + get}
+		}
+
+		r.Config.Workflows = append(r.Config.Workflows, newFlow)
+		workflow = newFlow.Name
 	}
 
 	/*
@@ -328,6 +430,15 @@ func main() {
 		log.Fatalln("--cmd or --workflow must be set!")
 	}
 
+	// Resuming is for workflows we're retrying
+	if resume {
+		if retryFailed == "" {
+			log.Fatalln("--resume requires --retry-failed")
+		} else if workflow == "" || workflow != retryWorkflow {
+			log.Fatalf("--resume requires running the same workflow as '%s'\n", retryFailed)
+		}
+	}
+
 	// Detect param swallowing
 	if strings.HasPrefix(cmd, "-") {
 		log.Fatalf("--cmd looks to be swallowing parameter '%s'\n", cmd)
@@ -337,10 +448,30 @@ func main() {
 		log.Fatalf("--workflow looks to be swallowing parameter '%s'\n", workflow)
 	}
 
-	// Constrain format
-	if format != "text" && format != "json" && format != "xml" {
-		log.Fatalln(`format must be one of "text", "json", or "xml"`)
+	// Output: Results as they arrive, in the format, and the Summary
+	output := deck.NewOutput(format, Log, Error)
+	output.Group = group
+	output.Quiet = quiet
+	if format == deck.FormatTemplate {
+		if templateFile == "" {
+			log.Fatalln("--format template requires --template")
+		}
+
+		var err error
+		if output.Template, err = deck.ParseTemplateFile(templateFile); err != nil {
+			log.Fatalf("Error parsing template: %s\n", err)
+		}
+	}
+	if err := output.Check(); err != nil {
+		log.Fatalf("Error: %s\n", err)
+	}
+
+	// Streamed lines go to the screen, so results can only go to a logfile
+	toScreen := logFile == "" || logFile == "STDOUT"
+	if stream && toScreen && format != deck.FormatText {
+		log.Fatalf("--stream with --format %s requires --logfile\n", format)
 	}
+	output.Streamed = stream && toScreen
 
 	// Sleepy?
 	{
@@ -356,7 +487,7 @@ func main() {
 	if cliVars != "" {
 		// CLI
 		for c, v := range strings.Split(cliVars, ",") {
-			GlobalVars[fmt.Sprintf("VAR%d", c+1)] = v
+			r.Vars[fmt.Sprintf("VAR%d", c+1)] = v
 		}
 	}
 
@@ -390,239 +521,113 @@ func main() {
 		auths = []ssh.AuthMethod{ssh.PublicKeys(key)}
 	}
 
-	// If workflow
-	//  - ensure the workflow exists
-	//  - cache the location of the specified workflow
-	//  - ensure we're not executing a must-chain flow
-	//  - ensure any required vars exist
-	//  - Do The Right Thing
-	if workflow != "" {
-		wfIndex = conf.WorkflowIndex(workflow)
-		if wfIndex < 0 {
-			// Does it exist?
-			log.Fatalf("Workflow '%s' does not exist in specified configs!\n", workflow)
-		} else if conf.Workflows[wfIndex].MustChain {
-			// Must we chain it?
-			log.Fatalf("Workflow '%s' must be used in a chain!\n", workflow)
-		} else {
-			// Are all the required CLI vars set?
-			req := conf.Workflows[wfIndex].VarsRequired
-			for _, r := range req {
-				if _, ok := GlobalVars[r]; !ok {
-					log.Fatalf("Workflow '%s' requires unset CLI var '%s'!\n", workflow, r)
-				}
-			}
-		}
-
-		if max == 0 {
-			// Autoconfig max execs
-			max = saneMaxLimitFromWorkflow(conf.Workflows[wfIndex])
-		}
-
-		// Init the workflow
-		conf.Workflows[wfIndex].Dnf = dnf
-		conf.Workflows[wfIndex].Init()
-
-	} else {
-		// not a workflow
-		wfIndex = -1
-		if max == 0 {
-			// Autoconfig max execs
-			max = saneMaxLimit(1)
+	// Host key checking
+	{
+		var err error
+		hkc, err = deck.NewHostKeyChecker(hostKeys, currentUser.HomeDir+"/.ssh/known_hosts", knownHosts)
+		if err != nil {
+			log.Fatalf("Error setting up host key checking: %s\n", err)
 		}
+		hkc.Debug = Debug
+		Debug.Printf("Host key checking is %s, using %v\n", hkc.Mode, hkc.Files)
 	}
 
-	// Autoconfig based on GOMAXPROCS (lame)
-	if max == -1 {
-		max = runtime.GOMAXPROCS(0)
+	// Rolling execution: CLI trumps workflow, which the Runner handles
+	batchSize, err := deck.ParseHostCount(batchStr)
+	if err != nil {
+		log.Fatalf("Error in --batch: %s\n", err)
+	}
+	maxFail, err := deck.ParseHostCount(maxFailStr)
+	if err != nil {
+		log.Fatalf("Error in --max-fail: %s\n", err)
 	}
 
-	// To keep things sane, we gate the number of goros that can be executing remote
-	// commands to a limit.
-	Debug.Printf("Max simultaneous execs set to %d\n", max)
-	sem := semaphore.NewSemaphore(max)
+	// Waves, and how to get between them
+	wavePause, err := deck.ParseWavePause(wavePauseStr)
+	if err != nil {
+		log.Fatalf("Error in --wavepause: %s\n", err)
+	}
 
-	// If we're doing a dryrun, this is the end of the line
-	if dryrun {
-		GlobalVars["dryrun"] = "yup"
+	/*
+	 * Everything else is the Runner's
+	 *
+	 */
+	r.Cmd = cmd
+	r.Workflow = workflow
+	r.User = userName
+	r.Auth = auths
+	r.HostKeys = hkc
+	r.Sudo = sudo
+	r.Dnf = dnf
+	r.DryRun = dryrun
+	r.Filter = filter
+	r.Wave = wave
+	r.Waves = allWaves
+	r.WavePause = wavePause
+	r.Batch = batchSize
+	r.MaxFail = maxFail
+	r.MaxExecs = max
+	r.Sleep = sleepFor
+	r.CommandTimeout = cmdTimeout
+	r.Timeout = time.Duration(timeout) * time.Second
+	r.Resume = resume
+
+	if err := r.Check(); err != nil {
+		log.Fatalf("Error: %s\n", err)
 	}
 
 	// Status bar!
-	// and then the collection phase
-	filteredHosts := conf.FilteredHostList(filter, wave, wfIndex)
-	filteredHostCount := len(filteredHosts)
-
+	filteredHostCount := len(r.Hosts())
 	Debug.Printf("FilteredHostCount: %d\n", filteredHostCount)
 	bar := pb.New(filteredHostCount)
 
-	if progressBar && logFile != "" {
+	if progressBar && logFile != "" && !stream {
 		Debug.Printf("BAR: Set to %d\n", filteredHostCount)
 		bar.Start()
-	}
-
-	// We've made it through checks and tests.
-	// Let's do this.
-	hostList := make(map[string]bool)
-	var hostCount time.Duration
-	for _, host := range filteredHosts {
-
-		// Add the host to the list, and set its return status to false
-		hostList[host.Name] = false
-		Debug.Printf("Host: %s\n", host.Name)
-
-		// Handle alternate usernames
-		configUser := userName
-		if host.User != "" {
-			configUser = host.User
-		}
-
-		// SSH Config
-		config := &ssh.ClientConfig{
-			User:            configUser,
-			Auth:            auths,
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		}
-
-		/*
-		 * This is where the work is getting accomplished.
-		 *   Workflows are configured sets of commands and logics, with sets of returns
-		 *   Commands are single directives, with single returns
-		 */
-
-		com := Command{Host: host, SSHConfig: config, Sudo: sudo}
-
-		var wait time.Duration
-		if sleepFor > 0 && hostCount > 0 {
-			wait = hostCount * sleepFor
+		defer bar.Finish()
+		r.Progress = func(done, total int) {
+			bar.SetCurrent(int64(done))
 		}
-		hostCount++
-
-		wg.Add(1)
-		if workflow != "" {
-			// Workflow
-
-			go func() {
-				defer wg.Done()
-				defer bar.Increment()
-
-				// Sleeeeep
-				Debug.Printf("Sleeping for %s\n", wait)
-				time.Sleep(wait)
-
-				sem.Lock()
-				defer sem.Unlock()
-
-				wfResults <- conf.Workflows[wfIndex].Exec(com)
-
-			}()
-
-			// Also, if there is a mintimeout, let's maybe use it
-			if conf.Workflows[wfIndex].MinTimeout > timeout {
-				timeout = conf.Workflows[wfIndex].MinTimeout
-			}
-		} else {
-			// Command
-			com.Cmd = cmd
-			go func() {
-				defer wg.Done()
-				defer bar.Increment()
-
-				// Sleeeeep
-				Debug.Printf("Sleeping for %s\n", wait)
-				time.Sleep(wait)
+	}
 
-				sem.Lock()
-				defer sem.Unlock()
+	// Streaming
+	if stream {
+		r.Stream = deck.NewLinePrinter(os.Stdout, os.Stderr, color, r.Hosts()).Print
+	}
 
-				commandResults <- com.Exec()
-			}()
+	// Everywhere the Results go
+	r.Sinks = append(r.Sinks, output)
+	if htmlFile != "" {
+		r.Sinks = append(r.Sinks, deck.NewHTMLFile(htmlFile, r.Filter, r.Hosts()))
+	}
+	if junitFile != "" {
+		name := r.Workflow
+		if name == "" {
+			name = r.Cmd
 		}
+		r.Sinks = append(r.Sinks, deck.NewJUnitFile(junitFile, name))
+	}
+	if outDir != "" {
+		r.Sinks = append(r.Sinks, &deck.OutDir{Dir: outDir})
+	}
+	if stateFile != "" && !dryrun {
+		// A dry run succeeds everywhere, which would wipe out the failures to retry
+		r.Sinks = append(r.Sinks, &deck.StateFile{Filename: stateFile})
 	}
 
-	/*
-	 * Post-run
-	 *
-	 */
-
-	// We wait for all the goros to finish up
-	for i := 0; i < len(hostList); i++ {
-		if workflow != "" {
-			// Workflow
-			select {
-			case res := <-wfResults:
-				hostList[res.HostObj.Name] = true // returned is good enough for this
-
-				if !res.Completed {
-					Error.Printf("Workflow %s did not fully complete\n", res.Name)
-				}
+	// We've made it through checks and tests.
+	// Let's do this.
 
-				if !quiet {
-					// Process all of the enclosed CommandReturns
-
-					for _, c := range res.CommandReturns {
-						if c.Quiet {
-							continue
-						}
-						switch format {
-						case "xml":
-							Log.Println(string(c.ToXML()))
-						case "json":
-							Log.Println(string(c.ToJSON(false)))
-						case "text":
-							fallthrough
-						default:
-							Log.Println(c.ToText())
-						}
-					}
+	// The first interrupt stops starting on hosts, and lets the running ones finish.
+	// The second terminates them.
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	r.Signals = sigs
 
-				}
-			case <-time.After(time.Duration(timeout) * time.Second):
-				var badHosts []string
-				for h, v := range hostList {
-					if !v {
-						badHosts = append(badHosts, h)
-					}
-				}
-				Error.Printf("Workflow operation timed out! The following hosts haven't returned: %s\n", badHosts)
-				return
-			}
-		} else {
-			// Command
-			select {
-			case res := <-commandResults:
-				hostList[res.HostObj.Name] = true // returned is good enough for this
-
-				if !quiet && !res.Quiet {
-					switch format {
-					case "xml":
-						Log.Println(string(res.ToXML()))
-					case "json":
-						Log.Println(string(res.ToJSON(false)))
-					case "text":
-						fallthrough
-					default:
-						Log.Println(res.ToText())
-					}
-				}
-			case <-time.After(time.Duration(timeout) * time.Second):
-				var badHosts []string
-				for h, v := range hostList {
-					if !v {
-						badHosts = append(badHosts, h)
-					}
-				}
-				Error.Printf("Command operation timed out! The following hosts haven't returned: %s\n", badHosts)
-				return
-			}
-		}
+	for range r.Run(context.Background()) {
+		// The Sinks have it
 	}
 
-	/*
-	 * Post-result, pre-exit cleanups
-	 *
-	 */
-	wg.Wait()
-	if progressBar && logFile != "" {
-		bar.Finish()
-	}
+	return r.Summary.ExitCode
 }
@@ -1,10 +1,10 @@
-package main
+package deck
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -13,7 +13,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
-func initAWS(awsRegion, awsAccessKey, awsSecretKey string) (AWSSession *session.Session) {
+func initAWS(awsRegion, awsAccessKey, awsSecretKey string) (AWSSession *session.Session, err error) {
 
 	AWSSession = session.New()
 
@@ -25,7 +25,7 @@ func initAWS(awsRegion, awsAccessKey, awsSecretKey string) (AWSSession *session.
 		region, err := getAwsRegionE()
 
 		if err != nil {
-			log.Fatalf("Cannot set AWS region: '%v'\n", err)
+			return nil, fmt.Errorf("cannot set AWS region: '%v'", err)
 		}
 		AWSSession.Config.Region = aws.String(region)
 	}
@@ -44,11 +44,50 @@ func initAWS(awsRegion, awsAccessKey, awsSecretKey string) (AWSSession *session.
 
 }
 
-func getAwsRegion() (region string) {
+// AWSRegion returns the AWS region from the environment, or the EC2 instance we're on
+func AWSRegion() (region string) {
 	region, _ = getAwsRegionE()
 	return
 }
 
+// AWSHosts returns a Config of the EC2 instances (that aren't Windows, and have a
+// private address) in the regions. If some regions fail, the hosts in the others are
+// still returned, along with the error.
+func AWSHosts(regions []string, accessKey, secretKey string) (conf Config, err error) {
+	var errs []string
+	for _, region := range regions {
+		session, serr := initAWS(region, accessKey, secretKey)
+		if serr != nil {
+			errs = append(errs, serr.Error())
+			continue
+		}
+
+		resp, serr := getEc2Instances(session)
+		if serr != nil {
+			errs = append(errs, serr.Error())
+			continue
+		}
+
+		for idx := range resp.Reservations {
+			for _, inst := range resp.Reservations[idx].Instances {
+				if inst.PrivateIpAddress == nil {
+					// Stopped, terminated, whatevs.
+					continue
+				}
+				if inst.Platform == nil || *inst.Platform != "windows" {
+					// Not Windows, phew
+					conf.AddHost(newHostFromInstance(inst))
+				}
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		err = fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return
+}
+
 func getAwsRegionE() (region string, err error) {
 
 	if os.Getenv("AWS_REGION") != "" {
@@ -89,6 +128,8 @@ func newHostFromInstance(inst *ec2.Instance) (h Host) {
 	}
 
 	var tags []string
+	tagMap := make(map[string]string)
+	vars := make(map[string]string)
 	for _, t := range inst.Tags {
 		// Handle special Tags
 		if *t.Key == "Name" {
@@ -97,6 +138,8 @@ func newHostFromInstance(inst *ec2.Instance) (h Host) {
 			h.User = *t.Value
 		} else if *t.Key == "sshport" {
 			h.Port, _ = strconv.Atoi(*t.Value)
+		} else if *t.Key == "jumphost" {
+			h.Jump = *t.Value
 		} else if *t.Key == "wave" {
 			h.Wave, _ = strconv.Atoi(*t.Value)
 		} else if *t.Key == "noall" {
@@ -104,18 +147,27 @@ func newHostFromInstance(inst *ec2.Instance) (h Host) {
 			h.Offline = true
 		} else if *t.Key == "dontupdatepackages" {
 			// They don't want certain yum updates
-			h.DontUpdatePackages = *t.Value
+			vars["dontupdatepackages"] = *t.Value
+		} else if strings.HasPrefix(*t.Key, "var.") {
+			// Host vars
+			if t.Value != nil {
+				vars[strings.TrimPrefix(*t.Key, "var.")] = *t.Value
+			}
 		} else {
 			var tag string
 			if t.Value == nil || *t.Value == "" {
 				tag = *t.Key
+				tagMap[*t.Key] = ""
 			} else {
 				tag = fmt.Sprintf("%s|%s", *t.Key, *t.Value)
+				tagMap[*t.Key] = *t.Value
 			}
 			tags = append(tags, tag)
 		}
 	}
 	h.Tags = tags
+	h.TagMap = tagMap
+	h.Vars = vars
 
 	return
 }
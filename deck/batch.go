@@ -0,0 +1,77 @@
+package deck
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HostCount is a number of hosts, either absolute ("10") or a percentage of the
+// hosts being run against ("10%"). The zero HostCount is unset.
+type HostCount struct {
+	N       int
+	Percent bool
+	set     bool
+}
+
+// ParseHostCount parses a "10" or "10%" string into a HostCount. An empty string is
+// an unset HostCount.
+func ParseHostCount(s string) (HostCount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return HostCount{}, nil
+	}
+
+	hc := HostCount{set: true}
+	if strings.HasSuffix(s, "%") {
+		hc.Percent = true
+		s = strings.TrimSuffix(s, "%")
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || (hc.Percent && n > 100) {
+		return HostCount{}, fmt.Errorf("'%s' is not a count, or a percentage", s)
+	}
+	hc.N = n
+	return hc, nil
+}
+
+// IsSet returns true if the HostCount was set to something
+func (c HostCount) IsSet() bool {
+	return c.set
+}
+
+// Of returns the number of hosts the HostCount is, of the total. Percentages are
+// rounded up, so that 10% of 5 hosts is 1 host, not 0.
+func (c HostCount) Of(total int) int {
+	if !c.Percent {
+		return c.N
+	}
+	return (c.N*total + 99) / 100
+}
+
+func (c HostCount) String() string {
+	if !c.set {
+		return ""
+	} else if c.Percent {
+		return strconv.Itoa(c.N) + "%"
+	}
+	return strconv.Itoa(c.N)
+}
+
+// batches splits the hosts into batches of size hosts, in order. If size is less
+// than 1, there is only one batch, of all of the hosts.
+func batches(hosts []Host, size int) (b [][]Host) {
+	if size < 1 || size >= len(hosts) {
+		if len(hosts) > 0 {
+			b = append(b, hosts)
+		}
+		return
+	}
+
+	for len(hosts) > size {
+		b = append(b, hosts[:size])
+		hosts = hosts[size:]
+	}
+	return append(b, hosts)
+}
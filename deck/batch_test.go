@@ -0,0 +1,93 @@
+package deck
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHostCount_Parse(t *testing.T) {
+	tests := []struct {
+		in      string
+		n       int
+		percent bool
+		set     bool
+	}{
+		{"", 0, false, false},
+		{"10", 10, false, true},
+		{" 25% ", 25, true, true},
+		{"0", 0, false, true},
+	}
+
+	for _, test := range tests {
+		hc, err := ParseHostCount(test.in)
+		if err != nil {
+			t.Errorf("Unexpected error parsing '%s': %s\n", test.in, err)
+			continue
+		}
+		if hc.N != test.n || hc.Percent != test.percent || hc.IsSet() != test.set {
+			t.Errorf("Parsing '%s' expected %d/%t/%t, got %d/%t/%t\n", test.in, test.n, test.percent, test.set, hc.N, hc.Percent, hc.IsSet())
+		}
+		if hc.String() != strings.TrimSpace(test.in) {
+			t.Errorf("Expected '%s' to String() as itself, got '%s'\n", test.in, hc.String())
+		}
+	}
+
+	for _, bad := range []string{"ten", "-1", "101%", "%", "1.5"} {
+		if _, err := ParseHostCount(bad); err == nil {
+			t.Errorf("Expected error parsing '%s', got none\n", bad)
+		}
+	}
+}
+
+func TestHostCount_Of(t *testing.T) {
+	tests := map[string][2]int{
+		"10":   {100, 10},
+		"10%":  {100, 10},
+		"10% ": {5, 1},
+		"50%":  {3, 2},
+		"0%":   {10, 0},
+		"100%": {7, 7},
+	}
+
+	for in, test := range tests {
+		hc, _ := ParseHostCount(in)
+		if n := hc.Of(test[0]); n != test[1] {
+			t.Errorf("Expected %s of %d to be %d, got %d\n", in, test[0], test[1], n)
+		}
+	}
+}
+
+func TestBatches(t *testing.T) {
+	hosts := make([]Host, 7)
+	for i := range hosts {
+		hosts[i].Name = string(rune('a' + i))
+	}
+
+	tests := map[int][]int{
+		0:  {7},
+		1:  {1, 1, 1, 1, 1, 1, 1},
+		3:  {3, 3, 1},
+		7:  {7},
+		10: {7},
+	}
+
+	for size, sizes := range tests {
+		b := batches(hosts, size)
+		if len(b) != len(sizes) {
+			t.Errorf("Expected %d batches of size %d, got %d\n", len(sizes), size, len(b))
+			continue
+		}
+		for i := range b {
+			if len(b[i]) != sizes[i] {
+				t.Errorf("Expected batch %d of size %d to have %d hosts, got %d\n", i, size, sizes[i], len(b[i]))
+			}
+		}
+		if b[len(b)-1][len(b[len(b)-1])-1].Name != "g" {
+			t.Errorf("Expected hosts to stay in order with size %d\n", size)
+		}
+	}
+
+	if b := batches(nil, 3); len(b) != 0 {
+		t.Errorf("Expected no batches of no hosts, got %d\n", len(b))
+	}
+}
@@ -0,0 +1,620 @@
+//go:build go1.4
+
+package deck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Classes of CommandReturn errors, for CommandReturn.ErrorType
+const (
+	// ErrorConnection is a failure connecting or authenticating to the host
+	ErrorConnection = "connection"
+	// ErrorSession is a failure setting up or running the session, other than the command's exit status
+	ErrorSession = "session"
+	// ErrorExit is the command exiting non-zero, or being killed by a signal
+	ErrorExit = "exit"
+	// ErrorTimeout is the command not completing before its timeout
+	ErrorTimeout = "timeout"
+	// ErrorCanceled is the command being terminated, because the run was cancelled
+	ErrorCanceled = "canceled"
+)
+
+// timeoutGrace is how long a timed-out command has to go away after being signalled,
+// before its session is torn down
+const timeoutGrace = 2 * time.Second
+
+// CommandReturn is a structure returned after executing a Command
+type CommandReturn struct {
+	Hostname   string
+	HostObj    Host
+	Error      error
+	ErrorType  string
+	ExitCode   int
+	ExitSignal string
+	TimedOut   bool
+	Command    string
+	Workflow   string
+	Step       int
+	Stdout     bytes.Buffer
+	Stderr     bytes.Buffer
+	Quiet      bool
+	// Start and End are when the command began, and finished
+	Start time.Time
+	End   time.Time
+	// Connect is how long connecting to the host took, 0 if an existing connection was
+	// used. Session is how long getting a session on it took, including waiting for
+	// one, and Exec how long executing the command took.
+	Connect time.Duration
+	Session time.Duration
+	Exec    time.Duration
+
+	// dontRestart are the processes needs-restarting output should never list
+	dontRestart string
+	// runner is the Runner that executed the command, for its loggers. May be nil.
+	runner *Runner
+}
+
+// Command is a structure to hold the necessary info to execute
+// a command
+type Command struct {
+	Cmd       string
+	Host      Host
+	SSHConfig *ssh.ClientConfig
+	Jumps     *JumpHosts
+	Conns     *Connections
+	Sudo      bool
+	Quiet     bool
+	Timeout   time.Duration
+	Step      int
+	// Runner is the Runner executing the Command, for its Vars and loggers. May be nil.
+	Runner *Runner
+}
+
+// safeBuffer is a bytes.Buffer that is safe to write to from a session that
+// may outlive our interest in it
+type safeBuffer struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (b *safeBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+// WriteTo writes a copy of the buffer's contents to w
+func (b *safeBuffer) WriteTo(w io.Writer) (int64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	n, err := w.Write(b.buf.Bytes())
+	return int64(n), err
+}
+
+// connectError wraps a failure to connect to a host, so it may be told apart from
+// session failures
+type connectError struct {
+	error
+}
+
+// commandOut is a helper struct to allow easier formating
+// of CommandResults for output
+type commandOut struct {
+	Name       string
+	Address    string
+	Workflow   string
+	Command    string
+	Date       time.Time
+	End        time.Time
+	Stdout     []string
+	Stderr     []string
+	Error      string
+	ErrorType  string
+	ExitCode   int
+	ExitSignal string
+}
+
+// StdoutString return the Stdout buffer as a string
+func (cr *CommandReturn) StdoutString(nullToSpace bool) string {
+	if nullToSpace {
+		s := bytes.Replace(cr.Stdout.Bytes(), []byte{00}, []byte(" "), -1)
+		return string(s)
+	}
+	return cr.Stdout.String()
+
+}
+
+// StderrString return the Stderr buffer as a string
+func (cr *CommandReturn) StderrString(nullToSpace bool) string {
+	if nullToSpace {
+		s := bytes.Replace(cr.Stderr.Bytes(), []byte{00}, []byte(" "), -1)
+		return string(s)
+	}
+	return cr.Stderr.String()
+}
+
+// StdoutStrings return the Stdout buffer as a string array
+func (cr *CommandReturn) StdoutStrings(nullToSpace bool) []string {
+	s := strings.Split(cr.StdoutString(nullToSpace), "\n")
+	return s[:len(s)-1]
+}
+
+// StderrStrings return the Stderr buffer as a string array
+func (cr *CommandReturn) StderrStrings(nullToSpace bool) []string {
+	s := strings.Split(cr.StderrString(nullToSpace), "\n")
+	return s[:len(s)-1]
+}
+
+// format the output of this CommandReturn
+func (cr *CommandReturn) format() commandOut {
+
+	f := commandOut{
+		Name:     cr.HostObj.Name,
+		Address:  cr.HostObj.Address,
+		Date:     cr.Start,
+		End:      cr.End,
+		Workflow: cr.Workflow,
+		Command:  cr.Command,
+
+		ErrorType:  cr.ErrorType,
+		ExitCode:   cr.ExitCode,
+		ExitSignal: cr.ExitSignal,
+	}
+
+	if cr.Error != nil {
+		f.Error = cr.Error.Error()
+	}
+
+	if strings.Contains(cr.Command, "needs-restarting") {
+		plist := needsRestartingMangler(cr.StdoutStrings(true), makeList([]string{cr.dontRestart}))
+		f.Stdout = []string{"Restart list:"}
+		f.Stdout = append(f.Stdout, plist...)
+	} else {
+		if cr.Stdout.Len() > 0 {
+			f.Stdout = cr.StdoutStrings(false)
+		}
+		if cr.Stderr.Len() > 0 {
+			f.Stderr = cr.StderrStrings(false)
+		}
+	}
+
+	return f
+}
+
+// ToXML formats the CommandReturn and outputs XML
+func (cr *CommandReturn) ToXML() []byte {
+	if cr.Quiet {
+		return nil
+	}
+
+	f := cr.format()
+
+	j, err := xml.Marshal(f)
+	if err != nil {
+		cr.runner.errorf("Error formatting XML: %s\n", err)
+	}
+
+	return j
+}
+
+// ToJSON formats the CommandReturn and outputs JSON
+func (cr *CommandReturn) ToJSON(pretty bool) (j []byte) {
+	if cr.Quiet {
+		return
+	}
+
+	f := cr.format()
+
+	var err error
+	if !pretty {
+		j, err = json.Marshal(f)
+	} else {
+		j, err = json.MarshalIndent(f, "", "\t")
+	}
+
+	if err != nil {
+		cr.runner.errorf("Error formatting JSON: %s\n", err)
+	}
+
+	return
+}
+
+// ToText formats the CommandReturn and outputs structured text
+func (cr *CommandReturn) ToText() (out string) {
+	if cr.Quiet {
+		return
+	}
+
+	f := cr.format()
+
+	out = out + fmt.Sprintf("%s (%s): %s\n", f.Name, f.Address, f.Command)
+	if len(f.Stdout) > 0 {
+		out = out + "STDOUT:\n"
+		for _, l := range f.Stdout {
+			out = out + l + "\n"
+		}
+	}
+	if len(f.Stderr) > 0 {
+		out = out + "STDERR:\n"
+		for _, l := range f.Stderr {
+			out = out + l + "\n"
+		}
+	}
+	if f.ErrorType == ErrorTimeout {
+		out = out + "TIMED OUT\n"
+	} else if f.ErrorType == ErrorCanceled {
+		out = out + "CANCELED\n"
+	} else if f.ExitSignal != "" {
+		out = out + "SIGNAL: " + f.ExitSignal + "\n"
+	} else if f.ExitCode != 0 {
+		out = out + fmt.Sprintf("EXIT: %d\n", f.ExitCode)
+	}
+	out = out + "END\n"
+
+	return
+}
+
+// Exec executes the Command structure, returning a CommandReturn
+func (c *Command) Exec() CommandReturn {
+	return c.ExecContext(context.Background())
+}
+
+// ExecContext executes the Command structure, returning a CommandReturn. If the
+// context is done, or the Command's Timeout elapses, before the command completes,
+// the remote command is signalled to terminate and its session is closed.
+func (c *Command) ExecContext(ctx context.Context) (cr CommandReturn) {
+
+	if c.Cmd == "" {
+		c.Runner.errorf("Command Exec request has no Cmd!\n")
+		cr.Error = fmt.Errorf("command Exec request has no Cmd")
+		return cr
+	}
+
+	cmd := c.Cmd
+
+	// Do we need to prepend sudo?
+	if c.Sudo {
+		cmd = "sudo " + cmd
+	}
+
+	c.Runner.debugf("Executing command '%s'\n", cmd)
+
+	cr = CommandReturn{
+		HostObj: c.Host,
+		Command: cmd,
+		Step:    c.Step,
+		Quiet:   c.Quiet,
+		Error:   nil,
+
+		dontRestart: c.Runner.vars()["dontrestart-processes"],
+		runner:      c.Runner,
+
+		Start: time.Now(),
+	}
+	defer cr.ended()
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var connectName string
+	if c.Host.Address != "" {
+		connectName = c.Host.Address
+	} else {
+		connectName = c.Host.Name
+	}
+	cr.Hostname = connectName
+
+	if !c.Runner.dryRun() {
+		// We're doing it live
+
+		session, done, err := c.session(ctx, &cr)
+		if err != nil {
+			c.sessionFailed(ctx, &cr, err)
+			return
+		}
+		defer done()
+
+		if c.Sudo {
+			// Set up terminal modes
+			modes := ssh.TerminalModes{
+				ssh.ECHO:          0,     // disable echoing
+				ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+				ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+			}
+			// Request pseudo terminal
+			if serr := session.RequestPty("xterm", 80, 80, modes); serr != nil {
+				c.Runner.errorf("Request for pseudo terminal on %s failed: %s", connectName, serr)
+				cr.Error = serr
+				cr.ErrorType = ErrorSession
+				return
+			}
+		}
+
+		// Set stdout/err to our byte buffers
+		var stdout, stderr safeBuffer
+		session.Stdout = &stdout
+		session.Stderr = &stderr
+		defer stdout.WriteTo(&cr.Stdout)
+		defer stderr.WriteTo(&cr.Stderr)
+
+		// and to the stream, if we're streaming
+		if w := c.Runner.stream(c, cmd, false); w != nil {
+			session.Stdout = io.MultiWriter(&stdout, w)
+			defer w.Flush()
+		}
+		if w := c.Runner.stream(c, cmd, true); w != nil {
+			session.Stderr = io.MultiWriter(&stderr, w)
+			defer w.Flush()
+		}
+
+		// With a pty, the remote may only understand ^C
+		var stdin io.WriteCloser
+		if c.Sudo {
+			stdin, _ = session.StdinPipe()
+		}
+
+		// Run the cmd
+		started := time.Now()
+		defer func() {
+			cr.Exec = time.Since(started)
+		}()
+		if err = session.Start(cmd); err != nil {
+			c.Runner.errorf("Execution of command failed on %s: %s", connectName, err)
+			cr.Error = err
+			cr.ErrorType = ErrorSession
+			return
+		}
+
+		waitErr := make(chan error, 1)
+		go func() {
+			waitErr <- session.Wait()
+		}()
+
+		select {
+		case err = <-waitErr:
+		case <-ctx.Done():
+			cr.interrupted("command", ctx.Err())
+			c.Runner.errorf("Command on %s: %s: %s\n", connectName, cr.Error, cmd)
+
+			session.Signal(ssh.SIGTERM)
+			if stdin != nil {
+				stdin.Write([]byte{3})
+			}
+			select {
+			case <-waitErr:
+			case <-time.After(timeoutGrace):
+				session.Close()
+			}
+			return
+		}
+
+		if err != nil {
+			c.Runner.errorf("Execution of command failed on %s: %s", connectName, err)
+			c.runFailed(&cr, err)
+		}
+	}
+
+	return
+
+}
+
+// ended records that the command has finished
+func (cr *CommandReturn) ended() {
+	cr.End = time.Now()
+}
+
+// Duration returns how long the command took
+func (cr *CommandReturn) Duration() time.Duration {
+	if cr.End.Before(cr.Start) {
+		return 0
+	}
+	return cr.End.Sub(cr.Start)
+}
+
+// sessionFailed records the failure to get a session in the CommandReturn
+func (c *Command) sessionFailed(ctx context.Context, cr *CommandReturn, err error) {
+	if ctx.Err() != nil {
+		cr.interrupted("waiting for a session", ctx.Err())
+		c.Runner.errorf("Command on %s: %s\n", c.Host.ConnectAddress(), cr.Error)
+		return
+	} else if _, ok := err.(connectError); ok {
+		c.Runner.errorf("Connection to %s failed: %s\n", c.Host.ConnectAddress(), err)
+		cr.ErrorType = ErrorConnection
+	} else {
+		c.Runner.errorf("Session to %s failed: %s\n", c.Host.ConnectAddress(), err)
+		cr.ErrorType = ErrorSession
+	}
+	cr.Error = err
+}
+
+// interrupted records the context being done, with err, before the command (or whatever
+// is described by what) completed in the CommandReturn: Either it timed out, or the run
+// was cancelled.
+func (cr *CommandReturn) interrupted(what string, err error) {
+	if err == context.Canceled {
+		cr.Error = fmt.Errorf("%s canceled", what)
+		cr.ErrorType = ErrorCanceled
+		return
+	}
+	cr.Error = fmt.Errorf("%s timed out: %s", what, err)
+	cr.ErrorType = ErrorTimeout
+	cr.TimedOut = true
+}
+
+// runFailed records the failure of a started session in the CommandReturn
+func (c *Command) runFailed(cr *CommandReturn, err error) {
+	cr.Error = err
+	if ee, ok := err.(*ssh.ExitError); ok {
+		cr.ErrorType = ErrorExit
+		cr.ExitCode = ee.ExitStatus()
+		cr.ExitSignal = ee.Signal()
+	} else {
+		// Includes the connection going away without an exit status
+		cr.ErrorType = ErrorSession
+	}
+}
+
+// session returns a new session to the Command's Host, from Conns if set, and
+// a function to call when finished with it. How long connecting, and getting the
+// session, took are recorded in the CommandReturn.
+func (c *Command) session(ctx context.Context, cr *CommandReturn) (*ssh.Session, func(), error) {
+	start := time.Now()
+	defer func() {
+		cr.Session = time.Since(start) - cr.Connect
+	}()
+
+	if c.Conns != nil {
+		return c.Conns.session(ctx, c, &cr.Connect)
+	}
+
+	conn, err := c.dial(ctx)
+	cr.Connect = time.Since(start)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return session, func() {
+		session.Close()
+		conn.Close()
+	}, nil
+}
+
+// dial connects to the Command's Host, through any jump hosts, giving up if the
+// context is done first. Errors are connectErrors.
+func (c *Command) dial(ctx context.Context) (client *ssh.Client, err error) {
+	chain := c.Host.JumpChain(c.Runner.vars()["jumphost"])
+	if len(chain) == 0 {
+		client, err = dialSSH(ctx, c.Host.ConnectAddress(), c.SSHConfig)
+	} else if c.Jumps == nil {
+		err = fmt.Errorf("host %s requires jump host %s, but no jump hosts are configured", c.Host.Name, chain)
+	} else {
+		client, err = c.Jumps.Dial(ctx, chain, c.Host.ConnectAddress(), c.SSHConfig)
+	}
+
+	if err != nil {
+		return nil, connectError{err}
+	}
+	return client, nil
+}
+
+// dialSSH connects to addr directly, and does the SSH handshake, giving up if the
+// context is done, or the config's Timeout elapses, first
+func dialSSH(ctx context.Context, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return handshake(ctx, conn, addr, config)
+}
+
+// handshake does the SSH handshake with addr over conn, giving up if the context is
+// done, or the config's Timeout elapses, first. conn is closed if it fails.
+func handshake(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	// Connections through jump hosts don't support deadlines, so closing the conn
+	// is what unblocks those
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		conn.SetDeadline(deadline)
+	}
+	shook := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-shook:
+		}
+	}()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	close(shook)
+	if err != nil {
+		conn.Close()
+		if hasDeadline && !time.Now().Before(deadline) {
+			// The conn's deadline may beat the context's by a hair
+			<-ctx.Done()
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("ssh handshake with %s: %s", addr, ctx.Err())
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// Given a list of services to operate on, Do The Right Thing
+func serviceList(ctx context.Context, op string, list []string, res chan<- CommandReturn, com Command) {
+
+	// sshd needs to restart first, completely, before other things fly
+	if op == "restart" {
+		for _, p := range list {
+			if p == "sshd" {
+				serviceCommand := "service " + p + " " + op + "; sleep 2"
+				com.Cmd = serviceCommand
+				res <- com.ExecContext(ctx)
+				break
+			}
+		}
+	}
+
+	for _, p := range list {
+		if p == "sshd" {
+			// Skip sshd, as we've already restarted it above, if appropriate
+			// or will stop it below, if appropriate
+			continue
+		}
+		com.Cmd = "service " + p + " " + op + "; sleep 2"
+
+		// We're executing these concurrently
+		go func(com Command) {
+			res <- com.ExecContext(ctx)
+		}(com)
+
+	}
+
+	// sshd needs to stop first, completely, after everything else
+	if op == "stop" {
+		for _, p := range list {
+			if p == "sshd" {
+				serviceCommand := "service " + p + " " + op + "; sleep 2"
+				com.Cmd = serviceCommand
+				res <- com.ExecContext(ctx)
+				break
+			}
+		}
+	}
+}
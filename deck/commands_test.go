@@ -0,0 +1,162 @@
+//go:build !windows && !plan9
+
+package deck
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCommand_ExitCode(t *testing.T) {
+	s := newTestSSHServer(t)
+
+	com := s.Command("echo oops >&2; exit 3")
+	cr := com.Exec()
+	if cr.Error == nil {
+		t.Fatal("Expected an error, got nil")
+	}
+	if cr.ErrorType != ErrorExit {
+		t.Errorf("Expected ErrorType '%s', got '%s'\n", ErrorExit, cr.ErrorType)
+	}
+	if cr.ExitCode != 3 {
+		t.Errorf("Expected ExitCode 3, got %d\n", cr.ExitCode)
+	}
+	if cr.StderrString(false) != "oops\n" {
+		t.Errorf("Expected stderr 'oops', got '%s'\n", cr.StderrString(false))
+	}
+	if cr.Start.IsZero() || cr.End.Before(cr.Start) {
+		t.Errorf("Expected Start before End, got %s and %s\n", cr.Start, cr.End)
+	}
+	if cr.Connect <= 0 || cr.Session <= 0 || cr.Exec <= 0 || cr.Connect+cr.Session+cr.Exec > cr.Duration() {
+		t.Errorf("Expected connect, session, and exec times within %s, got %s, %s, and %s\n", cr.Duration(), cr.Connect, cr.Session, cr.Exec)
+	}
+	if !strings.Contains(cr.ToText(), "EXIT: 3\n") {
+		t.Errorf("Expected exit code in text output, got '%s'\n", cr.ToText())
+	}
+	if !strings.Contains(string(cr.ToJSON(false)), `"ExitCode":3`) {
+		t.Errorf("Expected exit code in JSON output, got '%s'\n", cr.ToJSON(false))
+	}
+
+	com = s.Command("true")
+	if cr = com.Exec(); cr.Error != nil || cr.ErrorType != "" || cr.ExitCode != 0 {
+		t.Errorf("Expected clean return, got %v (%s) %d\n", cr.Error, cr.ErrorType, cr.ExitCode)
+	}
+}
+
+func TestCommandReturn_MarshalError(t *testing.T) {
+	var errs bytes.Buffer
+	cr := CommandReturn{
+		Command: "true",
+		// JSON times are years 0 to 9999
+		Start:  time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC),
+		runner: &Runner{Error: log.New(&errs, "", 0)},
+	}
+	cr.ToJSON(false)
+	if !strings.Contains(errs.String(), "Error formatting JSON") {
+		t.Errorf("Expected the error logged to the Runner, got '%s'\n", errs.String())
+	}
+}
+
+func TestCommand_ConnectionError(t *testing.T) {
+	s := newTestSSHServer(t)
+	com := s.Command("true")
+	s.Close()
+
+	cr := com.Exec()
+	if cr.Error == nil {
+		t.Fatal("Expected an error, got nil")
+	}
+	if cr.ErrorType != ErrorConnection {
+		t.Errorf("Expected ErrorType '%s', got '%s'\n", ErrorConnection, cr.ErrorType)
+	}
+}
+
+func TestCommand_Timeout(t *testing.T) {
+	s := newTestSSHServer(t)
+
+	com := s.Command("sleep 10")
+	com.Timeout = 200 * time.Millisecond
+
+	start := time.Now()
+	cr := com.Exec()
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Expected timeout to return promptly, took %s\n", d)
+	}
+	if !cr.TimedOut || cr.ErrorType != ErrorTimeout || cr.Error == nil {
+		t.Errorf("Expected timeout, got %v (%s)\n", cr.Error, cr.ErrorType)
+	}
+	if !strings.Contains(cr.ToText(), "TIMED OUT\n") {
+		t.Errorf("Expected timeout in text output, got '%s'\n", cr.ToText())
+	}
+}
+
+func TestCommand_TimeoutConnecting(t *testing.T) {
+	// Accepts connections, but never says anything
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	s := newTestSSHServer(t)
+	com := s.Command("true")
+	com.Host.Port = l.Addr().(*net.TCPAddr).Port
+	com.Timeout = 200 * time.Millisecond
+
+	start := time.Now()
+	cr := com.Exec()
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Expected timeout to return promptly, took %s\n", d)
+	}
+	if !cr.TimedOut || cr.ErrorType != ErrorTimeout {
+		t.Errorf("Expected timeout, got %v (%s)\n", cr.Error, cr.ErrorType)
+	}
+
+	// Through a jump host
+	s.Host.Name = "bastion"
+	com.Host.Jump = "bastion"
+	com.Jumps = NewJumpHosts([]Host{s.Host}, func(Host) *ssh.ClientConfig {
+		return s.Command("").SSHConfig
+	})
+	defer com.Jumps.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	com.Timeout = 0
+	start = time.Now()
+	cr = com.ExecContext(ctx)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Expected cancellation to return promptly, took %s\n", d)
+	}
+	if cr.ErrorType != ErrorCanceled {
+		t.Errorf("Expected cancellation, got %v (%s)\n", cr.Error, cr.ErrorType)
+	}
+
+	// With nothing else to give up on it
+	com.Host.Jump = ""
+	com.SSHConfig.Timeout = 200 * time.Millisecond
+	start = time.Now()
+	cr = com.Exec()
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Expected the connect timeout to return promptly, took %s\n", d)
+	}
+	if cr.ErrorType != ErrorConnection {
+		t.Errorf("Expected a connection error, got %v (%s)\n", cr.Error, cr.ErrorType)
+	}
+}
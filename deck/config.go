@@ -0,0 +1,216 @@
+package deck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Config is a toplevel struct to house arrays of Hosts, Workflows, and Miscs
+type Config struct {
+	Hosts     []Host
+	Workflows []Workflow
+	Miscs     []Misc
+}
+
+// AddHost adds a Host to Hosts
+func (c *Config) AddHost(h Host) {
+	c.Hosts = append(c.Hosts, h)
+}
+
+// Merge properly merges the provided Config, into the parent Config
+func (c *Config) Merge(conf Config) {
+	c.Hosts = append(c.Hosts, conf.Hosts...)
+	c.Workflows = append(c.Workflows, conf.Workflows...)
+	c.Miscs = append(c.Miscs, conf.Miscs...)
+}
+
+// WorkflowIndex finds the named workflow in the Config, and
+// returns its index, or -1 if it is not found
+func (c *Config) WorkflowIndex(workflow string) int {
+	var flowIndex = -1
+	for i, wf := range c.Workflows {
+		if wf.Name == workflow {
+			flowIndex = i
+			break
+		}
+	}
+	return flowIndex
+}
+
+// ChainWorkflows adds a workflow named for the comma-separated list of workflows, merging
+// them in order, unless it isn't a list, or has already been added
+func (c *Config) ChainWorkflows(list string) error {
+	if !strings.Contains(list, ",") || c.WorkflowIndex(list) >= 0 {
+		return nil
+	}
+
+	newFlow := Workflow{
+		Name:      list,
+		MustChain: false,
+	}
+	for _, name := range strings.Split(list, ",") {
+		wfi := c.WorkflowIndex(name)
+		if wfi < 0 {
+			return fmt.Errorf("workflow '%s' in chain does not exist in specified configs", name)
+		}
+		newFlow.Merge(&c.Workflows[wfi])
+	}
+
+	c.Workflows = append(c.Workflows, newFlow)
+	return nil
+}
+
+// Check validates the Config, returning an error if any workflow has an invalid
+// Filter or batch policy, chains to a workflow that doesn't exist, or chains back to itself
+func (c *Config) Check() error {
+	for _, wf := range c.Workflows {
+		if _, err := cachedFilter(wf.Filter); err != nil {
+			return fmt.Errorf("workflow '%s': %s", wf.Name, err)
+		}
+		if _, err := ParseHostCount(wf.Batch); err != nil {
+			return fmt.Errorf("workflow '%s' batch: %s", wf.Name, err)
+		}
+		if _, err := ParseHostCount(wf.MaxFail); err != nil {
+			return fmt.Errorf("workflow '%s' maxfail: %s", wf.Name, err)
+		}
+		if err := c.checkChain(wf.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkChain walks the workflow chain from the named workflow, returning an error
+// if it loops, or goes nowhere
+func (c *Config) checkChain(name string, path []string) error {
+	for _, p := range path {
+		if p == name {
+			return fmt.Errorf("workflow chain loops: %s -> %s", strings.Join(path, " -> "), name)
+		}
+	}
+
+	wfi := c.WorkflowIndex(name)
+	if wfi < 0 {
+		return fmt.Errorf("workflow '%s' chains to workflow '%s', which does not exist", path[len(path)-1], name)
+	}
+
+	path = append(path, name)
+	for _, command := range c.Workflows[wfi].Commands {
+		if next := chainName(command); next != "" {
+			if err := c.checkChain(next, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// InitWorkflow initializes the indexed Workflow, and any workflows it chains to,
+// with the specified vars (see Workflow.InitVars)
+func (c *Config) InitWorkflow(workflowIndex int, vars map[string]string) error {
+	wf := &c.Workflows[workflowIndex]
+	err := wf.InitVars(vars)
+	if cerr := c.initChains(wf); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// initChains initializes copies of the workflows the Workflow chains to, sharing its
+// vars, and attaches them to it
+func (c *Config) initChains(wf *Workflow) error {
+	var err error
+	for _, command := range wf.Commands {
+		name := chainName(command)
+		if name == "" {
+			continue
+		}
+		if _, ok := wf.chained[name]; ok {
+			continue
+		}
+
+		wfi := c.WorkflowIndex(name)
+		if wfi < 0 {
+			// Check() should have caught this
+			err = fmt.Errorf("workflow '%s' chains to workflow '%s', which does not exist", wf.Name, name)
+			continue
+		}
+
+		// Init mangles Commands, so we need our own
+		sub := c.Workflows[wfi]
+		sub.Commands = append([]string(nil), sub.Commands...)
+		sub.Dnf = wf.Dnf
+		if serr := sub.initWith(wf.globals, wf.vars); err == nil {
+			err = serr
+		}
+		if serr := c.initChains(&sub); err == nil {
+			err = serr
+		}
+
+		wf.chained[name] = &sub
+	}
+	return err
+}
+
+// FilteredHostList returns the hosts that match a filter
+func (c *Config) FilteredHostList(filter string, wave, workflowIndex int) (hosts []Host) {
+
+	for _, host := range c.Hosts {
+
+		if host.Offline {
+			// Check to see if the host is offline
+			continue
+		} else if wave > 0 && host.Wave != wave {
+			// Check to see if we're using waves, and if this is in it
+			continue
+		} else if filter != "" && !host.If(filter) {
+			// Check to see if the this host matches our filter
+			continue
+		} else if workflowIndex >= 0 && !host.If(c.Workflows[workflowIndex].Filter) {
+			// Check to see if we're using workflows, and if this is in it
+			continue
+		}
+
+		// POST: We're interested in this host
+		hosts = append(hosts, host)
+	}
+
+	return
+}
+
+// Dump returns a formatted JSON string representation of the config
+func (c *Config) Dump() string {
+	j, _ := json.MarshalIndent(c, "", "\t")
+	return string(j)
+}
+
+// LoadConfigs loads all of the configs (*.json) in the directory
+func LoadConfigs(srcDir string) (conf Config, err error) {
+	for _, f := range readDirectory(srcDir, "*.json") {
+		if conf, err = LoadConfigFile(f, conf); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// LoadConfigFile loads the given config file into the specified config
+func LoadConfigFile(filePath string, conf Config) (Config, error) {
+
+	buf, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return conf, fmt.Errorf("error reading config file '%s': %s", filePath, err)
+	}
+
+	var newConf Config
+
+	err = json.Unmarshal(buf, &newConf)
+	if err != nil {
+		return conf, fmt.Errorf("error parsing JSON in config file '%s': %s", filePath, err)
+	}
+
+	conf.Merge(newConf)
+	return conf, nil
+}
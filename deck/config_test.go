@@ -0,0 +1,130 @@
+package deck
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestConfig_LoadConfigs(t *testing.T) {
+
+	conf, err := LoadConfigs("testconfigs/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+	if len(conf.Hosts) < 1 {
+		t.Error("Expected at least one Host, got 0")
+	}
+	if len(conf.Workflows) < 1 {
+		t.Error("Expected at least one Workflow, got 0")
+	}
+	if len(conf.Miscs) < 1 {
+		t.Error("Expected at least one Misc, got 0")
+	}
+}
+
+func TestConfig_LoadConfigsErrors(t *testing.T) {
+	if _, err := LoadConfigFile("testconfigs/NOPE.json", Config{}); err == nil {
+		t.Error("Expected error loading missing config file")
+	}
+
+	bad := t.TempDir()
+	if err := ioutil.WriteFile(bad+"/bad.json", []byte("{nope"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfigs(bad + "/"); err == nil {
+		t.Error("Expected error loading bad config file")
+	}
+}
+
+func TestConfig_ConfigMerge(t *testing.T) {
+
+	// empties
+	var c1 Config
+	var c2 Config
+	var c3 Config
+
+	c4, _ := LoadConfigFile("testconfigs/testdevhosts.json", c1)
+	num1 := len(c4.Hosts)
+	c5, _ := LoadConfigFile("testconfigs/testprodhosts.json", c2)
+	num2 := len(c5.Hosts)
+	c6, _ := LoadConfigFile("testconfigs/testmoarhosts.json", c3)
+	num3 := len(c6.Hosts)
+	merge1 := c4
+	merge1.Merge(c5)
+
+	if len(merge1.Hosts) != num1+num2 {
+		t.Errorf("Expected %d, got %d\n", num1+num2, len(merge1.Hosts))
+	}
+
+	c6.Merge(merge1)
+	if len(c6.Hosts) != num1+num2+num3 {
+		t.Errorf("Expected %d, got %d\n", num1+num2+num3, len(c6.Hosts))
+	}
+}
+
+func TestConfig_WorkflowIndex(t *testing.T) {
+	conf, err := LoadConfigFile("testconfigs/testflows.json", Config{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+
+	if len(conf.Workflows) != 5 {
+		t.Error("Expected 5 workflows, got ", len(conf.Workflows))
+	}
+
+	if conf.WorkflowIndex("restart-tomcat") < 0 {
+		t.Error("Unexpectedly not finding workflow 'restart-tomcat'")
+	}
+
+	if conf.WorkflowIndex("NOPE") >= 0 {
+		t.Error("Unexpectedly found workflow 'NOPE'")
+	}
+}
+
+func TestConfig_WorkflowChain(t *testing.T) {
+	conf := Config{
+		Workflows: []Workflow{
+			{Name: "top", Commands: []string{"uptime", "%%middle"}},
+			{Name: "middle", Commands: []string{"%%bottom", "%%bottom"}},
+			{Name: "bottom", MustChain: true, Commands: []string{"whoami"}},
+		},
+	}
+
+	if err := conf.Check(); err != nil {
+		t.Errorf("Expected valid chain, got: %s\n", err)
+	}
+
+	conf.Workflows[2].Commands = append(conf.Workflows[2].Commands, "%%top")
+	if err := conf.Check(); err == nil {
+		t.Error("Expected chain loop error, got nil")
+	}
+
+	conf.Workflows[2].Commands = []string{"%%NOPE"}
+	if err := conf.Check(); err == nil {
+		t.Error("Expected missing chained workflow error, got nil")
+	}
+}
+
+func TestConfig_WorkflowChainInit(t *testing.T) {
+	conf := Config{
+		Workflows: []Workflow{
+			{Name: "top", Commands: []string{"SET %DIR% /tmp/top", "%%bottom"}},
+			{Name: "bottom", Commands: []string{"ls %DIR%"}},
+		},
+	}
+
+	if err := conf.InitWorkflow(0, nil); err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+
+	sub, ok := conf.Workflows[0].chained["bottom"]
+	if !ok {
+		t.Fatal("Expected chained workflow to be initialized")
+	}
+	if sub.Commands[0] != "ls /tmp/top" {
+		t.Errorf("Expected chained workflow to share vars, got '%s'\n", sub.Commands[0])
+	}
+	if conf.Workflows[1].Commands[0] != "ls %DIR%" {
+		t.Errorf("Expected original workflow to be untouched, got '%s'\n", conf.Workflows[1].Commands[0])
+	}
+}
@@ -0,0 +1,603 @@
+package deck
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// filterCache caches parsed filters, so each is only parsed once per run, no matter how
+// many hosts it's applied to
+var filterCache = struct {
+	filters map[string]*Filter
+	lock    sync.Mutex
+}{filters: make(map[string]*Filter)}
+
+/*
+Filter is a parsed filter expression, which may be matched against Hosts.
+
+Statements are "Field operator value", and may be combined with "and" (or "&&") and
+"or" (or "||"), negated with "not" (or "!"), and grouped with parentheses. Without
+parentheses, "not" binds tightest, then "or", then "and", so
+
+	Tags == dev and Tags == httpd or Tags == haproxy and not Name == ugly
+
+is
+
+	Tags == dev and (Tags == httpd or Tags == haproxy) and (not Name == ugly)
+
+Key/value tags are matched with "Tag[key] operator value", and host vars with
+"Var[key] operator value". "has Tag[key]" and "has Var[key]" are true if the Host
+has the tag or var, regardless of value.
+
+Operators are == and != (exact), ~= and ~! (substring), =~ and !~ (regular
+expression), glob and !glob (shell glob), < <= > >= (Port and Wave only), and
+"in [a, b, c]" (any of the list).
+
+Values containing spaces or unbalanced parentheses must be quoted.
+*/
+type Filter struct {
+	Expr string
+	root filterNode
+}
+
+// FilterError is a syntax error in a filter expression
+type FilterError struct {
+	Expr   string
+	Column int
+	Msg    string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("filter syntax error at column %d: %s, in '%s'", e.Column, e.Msg, e.Expr)
+}
+
+// filterNode is an element of a parsed filter
+type filterNode interface {
+	match(h *Host) bool
+}
+
+type andNode []filterNode
+
+func (n andNode) match(h *Host) bool {
+	for _, c := range n {
+		if !c.match(h) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []filterNode
+
+func (n orNode) match(h *Host) bool {
+	for _, c := range n {
+		if c.match(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasNode is a "has Tag[key]" or "has Var[key]" statement
+type hasNode struct {
+	field string
+	key   string
+}
+
+func (n hasNode) match(h *Host) bool {
+	if n.field == "Var" {
+		_, ok := h.Var(n.key)
+		return ok
+	}
+	_, ok := h.Tag(n.key)
+	return ok
+}
+
+type notNode struct {
+	node filterNode
+}
+
+func (n notNode) match(h *Host) bool {
+	return !n.node.match(h)
+}
+
+// compareNode is a single "Field operator value" statement
+type compareNode struct {
+	field string
+	key   string
+	op    string
+	value string
+	num   int
+	list  []string
+	nums  []int
+	re    *regexp.Regexp
+}
+
+func (n *compareNode) match(h *Host) bool {
+	if n.field == "Port" || n.field == "Wave" {
+		return n.matchNum(h)
+	}
+
+	var values []string
+	switch n.field {
+	case "Tags":
+		values = h.Tags
+	case "Tag":
+		if v, ok := h.Tag(n.key); ok {
+			values = []string{v}
+		}
+	case "Var":
+		if v, ok := h.Var(n.key); ok {
+			values = []string{v}
+		}
+	case "Address":
+		values = []string{h.Address}
+	case "Loc":
+		values = []string{h.Loc}
+	case "Name":
+		values = []string{h.Name}
+	case "Arch":
+		values = []string{h.Arch}
+	case "User":
+		// caveat: We don't have access to the CLI-specified user,
+		// so this only matches a host-specified user
+		values = []string{h.User}
+	}
+
+	found := false
+	for _, v := range values {
+		if n.matchString(v) {
+			found = true
+			break
+		}
+	}
+
+	if negativeOp(n.op) {
+		return !found
+	}
+	return found
+}
+
+// matchString returns true if the value matches the statement, ignoring negation
+func (n *compareNode) matchString(v string) bool {
+	switch n.op {
+	case "~=", "~!":
+		return strings.Contains(v, n.value)
+	case "=~", "!~":
+		return n.re.MatchString(v)
+	case "glob", "!glob":
+		ok, _ := path.Match(n.value, v)
+		return ok
+	case "in":
+		for _, l := range n.list {
+			if v == l {
+				return true
+			}
+		}
+		return false
+	}
+	return v == n.value
+}
+
+// matchNum matches the numeric fields
+func (n *compareNode) matchNum(h *Host) bool {
+	var (
+		v  int
+		ok bool
+	)
+	switch n.field {
+	case "Port":
+		// we started allowing port to be skipped
+		v, ok = h.Port, true
+		if v == 0 {
+			v = 22
+		}
+	case "Wave":
+		// Wave 0 is no wave, and never matches
+		v, ok = h.Wave, h.Wave != 0
+	}
+
+	found := false
+	if ok {
+		switch n.op {
+		case "<":
+			found = v < n.num
+		case "<=":
+			found = v <= n.num
+		case ">":
+			found = v > n.num
+		case ">=":
+			found = v >= n.num
+		case "in":
+			for _, l := range n.nums {
+				if v == l {
+					found = true
+					break
+				}
+			}
+		case "=~", "!~", "glob", "!glob":
+			found = n.matchString(strconv.Itoa(v))
+		default:
+			found = v == n.num
+		}
+	}
+
+	if negativeOp(n.op) {
+		return !found
+	}
+	return found
+}
+
+// negativeOp returns true if the operator is a "not" operator
+func negativeOp(op string) bool {
+	switch op {
+	case "!=", "~!", "!~", "!glob":
+		return true
+	}
+	return false
+}
+
+// ParseFilter parses the filter expression, returning a *FilterError if it is invalid
+func ParseFilter(expr string) (*Filter, error) {
+	f := &Filter{Expr: expr}
+	if strings.TrimSpace(expr) == "" {
+		return f, nil
+	}
+
+	p := &filterParser{expr: expr}
+	root, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	if tok, pos := p.peek(); tok != "" {
+		return nil, p.errorf(pos, "unexpected '%s'", tok)
+	}
+
+	f.root = root
+	return f, nil
+}
+
+// cachedFilter returns the parsed filter expression, parsing it if it hasn't been already
+func cachedFilter(expr string) (*Filter, error) {
+	filterCache.lock.Lock()
+	defer filterCache.lock.Unlock()
+
+	if f, ok := filterCache.filters[expr]; ok {
+		return f, nil
+	}
+
+	f, err := ParseFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	filterCache.filters[expr] = f
+	return f, nil
+}
+
+// Match returns true if the Host matches the Filter. Empty Filters match everything.
+func (f *Filter) Match(h *Host) bool {
+	if f == nil || f.root == nil {
+		return true
+	}
+	return f.root.match(h)
+}
+
+// filterParser is a recursive descent parser for filter expressions
+type filterParser struct {
+	expr string
+	pos  int
+}
+
+func (p *filterParser) errorf(pos int, format string, args ...interface{}) error {
+	return &FilterError{
+		Expr:   p.expr,
+		Column: pos + 1,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (p *filterParser) skipSpace() {
+	for p.pos < len(p.expr) && unicode.IsSpace(rune(p.expr[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next token, and its position, without consuming it. Tokens are
+// parentheses, or runs of anything but whitespace and parentheses.
+func (p *filterParser) peek() (string, int) {
+	p.skipSpace()
+	if p.pos >= len(p.expr) {
+		return "", p.pos
+	}
+
+	if c := p.expr[p.pos]; c == '(' || c == ')' {
+		return string(c), p.pos
+	}
+
+	end := p.pos
+	for end < len(p.expr) {
+		c := p.expr[end]
+		if unicode.IsSpace(rune(c)) || c == '(' || c == ')' {
+			break
+		}
+		end++
+	}
+	return p.expr[p.pos:end], p.pos
+}
+
+// next returns the next token, and its position, consuming it
+func (p *filterParser) next() (string, int) {
+	tok, pos := p.peek()
+	p.pos += len(tok)
+	return tok, pos
+}
+
+// value returns the next value, and its position. Values are quoted strings, or
+// runs of anything but whitespace, ending early at an unbalanced closing parenthesis
+// or bracket. In lists, values also end at a comma.
+func (p *filterParser) value(list bool) (string, int, error) {
+	p.skipSpace()
+	start := p.pos
+	if p.pos >= len(p.expr) {
+		return "", start, p.errorf(start, "expected a value")
+	}
+
+	if q := p.expr[p.pos]; q == '"' || q == '\'' {
+		var sb strings.Builder
+		for p.pos++; p.pos < len(p.expr); p.pos++ {
+			c := p.expr[p.pos]
+			if c == '\\' && p.pos+1 < len(p.expr) {
+				p.pos++
+				sb.WriteByte(p.expr[p.pos])
+			} else if c == q {
+				p.pos++
+				return sb.String(), start, nil
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+		return "", start, p.errorf(start, "unterminated quote")
+	}
+
+	depth := 0
+	for p.pos < len(p.expr) {
+		c := p.expr[p.pos]
+		if unicode.IsSpace(rune(c)) || (list && depth == 0 && c == ',') {
+			break
+		} else if c == '(' || c == '[' {
+			depth++
+		} else if c == ')' || c == ']' {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+		p.pos++
+	}
+
+	if p.pos == start {
+		return "", start, p.errorf(start, "expected a value")
+	}
+	return p.expr[start:p.pos], start, nil
+}
+
+// list parses a bracketed, comma-delimited list of values
+func (p *filterParser) list() ([]string, []int, error) {
+	p.skipSpace()
+	if p.pos >= len(p.expr) || p.expr[p.pos] != '[' {
+		return nil, nil, p.errorf(p.pos, "expected '[' to start a list")
+	}
+	open := p.pos
+	p.pos++
+
+	var (
+		vals      []string
+		positions []int
+	)
+	for {
+		val, vpos, err := p.value(true)
+		if err != nil {
+			return nil, nil, err
+		}
+		vals = append(vals, val)
+		positions = append(positions, vpos)
+
+		p.skipSpace()
+		if p.pos >= len(p.expr) {
+			return nil, nil, p.errorf(open, "unclosed list")
+		}
+		c := p.expr[p.pos]
+		p.pos++
+		if c == ']' {
+			return vals, positions, nil
+		} else if c != ',' {
+			return nil, nil, p.errorf(p.pos-1, "expected ',' or ']' in list")
+		}
+	}
+}
+
+// parseAnd parses statements joined by "and"
+func (p *filterParser) parseAnd() (filterNode, error) {
+	var nodes andNode
+	for {
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+
+		if tok, _ := p.peek(); tok != "&&" && !strings.EqualFold(tok, "and") {
+			break
+		}
+		p.next()
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+// parseOr parses statements joined by "or"
+func (p *filterParser) parseOr() (filterNode, error) {
+	var nodes orNode
+	for {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+
+		if tok, _ := p.peek(); tok != "||" && !strings.EqualFold(tok, "or") {
+			break
+		}
+		p.next()
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+// parseUnary parses a negation, a parenthesized group, or a statement
+func (p *filterParser) parseUnary() (filterNode, error) {
+	tok, pos := p.peek()
+
+	switch {
+	case tok == "":
+		return nil, p.errorf(pos, "unexpected end of filter")
+	case strings.HasPrefix(tok, "!"):
+		// "!" may be right up against what it negates
+		p.pos = pos + 1
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case strings.EqualFold(tok, "not"):
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case strings.EqualFold(tok, "has"):
+		p.next()
+		field, fpos := p.next()
+		field, key, ok := keyedField(field)
+		if !ok {
+			return nil, p.errorf(fpos, "expected 'Tag[key]' or 'Var[key]' after '%s'", tok)
+		}
+		if key == "" {
+			return nil, p.errorf(fpos, "empty %s key", field)
+		}
+		return hasNode{field, key}, nil
+	case tok == "(":
+		p.next()
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if closing, _ := p.next(); closing != ")" {
+			return nil, p.errorf(pos, "unclosed parenthesis")
+		}
+		return n, nil
+	case tok == ")":
+		return nil, p.errorf(pos, "unexpected ')'")
+	}
+
+	return p.parseStatement()
+}
+
+// parseStatement parses a "Field operator value" statement
+func (p *filterParser) parseStatement() (filterNode, error) {
+	field, fpos := p.next()
+	field, key, keyed := keyedField(field)
+	switch {
+	case keyed:
+		if key == "" {
+			return nil, p.errorf(fpos, "empty %s key", field)
+		}
+	case field == "Tags", field == "Port", field == "Wave", field == "Address",
+		field == "Loc", field == "Name", field == "Arch", field == "User":
+	default:
+		return nil, p.errorf(fpos, "conditional name '%s' does not exist", field)
+	}
+
+	op, opos := p.next()
+	switch strings.ToLower(op) {
+	case "==", "!=", "~=", "~!", "=~", "!~":
+	case "glob", "!glob", "in":
+		op = strings.ToLower(op)
+	case "<", "<=", ">", ">=":
+		if field != "Port" && field != "Wave" {
+			return nil, p.errorf(opos, "operator '%s' only works with Port and Wave", op)
+		}
+	case "":
+		return nil, p.errorf(opos, "expected an operator after '%s'", field)
+	default:
+		return nil, p.errorf(opos, "operator '%s' does not exist", op)
+	}
+
+	n := &compareNode{field: field, key: key, op: op}
+	numeric := field == "Port" || field == "Wave"
+
+	if op == "in" {
+		list, positions, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		n.list = list
+		if numeric {
+			for i, l := range list {
+				num, err := strconv.Atoi(l)
+				if err != nil {
+					return nil, p.errorf(positions[i], "%s value '%s' is not a number", field, l)
+				}
+				n.nums = append(n.nums, num)
+			}
+		}
+		return n, nil
+	}
+
+	val, vpos, err := p.value(false)
+	if err != nil {
+		return nil, err
+	}
+	n.value = val
+
+	switch op {
+	case "=~", "!~":
+		if n.re, err = regexp.Compile(val); err != nil {
+			return nil, p.errorf(vpos, "invalid regular expression: %s", err)
+		}
+	case "glob", "!glob":
+		if _, err = path.Match(val, ""); err != nil {
+			return nil, p.errorf(vpos, "invalid glob '%s'", val)
+		}
+	default:
+		if numeric {
+			if n.num, err = strconv.Atoi(val); err != nil {
+				return nil, p.errorf(vpos, "%s value '%s' is not a number", field, val)
+			}
+		}
+	}
+	return n, nil
+}
+
+// keyedField splits a "Tag[key]" or "Var[key]" field into its name and key, and
+// returns whether it is one. Other fields are returned as-is.
+func keyedField(field string) (string, string, bool) {
+	for _, name := range []string{"Tag", "Var"} {
+		if strings.HasPrefix(field, name+"[") && strings.HasSuffix(field, "]") {
+			return name, field[len(name)+1 : len(field)-1], true
+		}
+	}
+	return field, "", false
+}
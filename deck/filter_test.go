@@ -0,0 +1,178 @@
+package deck
+
+import (
+	"testing"
+)
+
+func TestFilter_Precedence(t *testing.T) {
+	tests := map[string]bool{
+		"Tags == dev and Tags == httpd or Tags == haproxy":                       true,
+		"Tags == haproxy or Tags == dev and Tags == nope":                        false,
+		"(Tags == haproxy or Tags == dev) and Tags == nope":                      false,
+		"Tags == nope and Tags == haproxy or (Tags == dev)":                      false,
+		"(Tags == nope and Tags == haproxy) or Tags == dev":                      true,
+		"((Tags == nope and Tags == haproxy)) || (Tags == dev && Tags == daisy)": true,
+	}
+
+	for filter, expected := range tests {
+		if got := host2.If(filter); got != expected {
+			t.Errorf("'%s' should be %t, but %t!\n", filter, expected, got)
+		}
+	}
+}
+
+func TestFilter_Not(t *testing.T) {
+	tests := map[string]bool{
+		"not Tags == dev":                       false,
+		"NOT Tags == nope":                      true,
+		"! Tags == dev":                         false,
+		"not not Tags == dev":                   true,
+		"not (Tags == nope or Tags == haproxy)": true,
+		"!(Tags == dev)":                        false,
+		"not Tags == nope and Tags == dev":      true,
+		"!Tags == dev":                          false,
+		"!Name == x":                            true,
+		"!Tags != dev":                          true,
+		"!!Tags == dev":                         true,
+		"!(!Tags == dev)":                       true,
+		"Tags == dev and !(Tags == nope)":       true,
+	}
+
+	for filter, expected := range tests {
+		if got := host2.If(filter); got != expected {
+			t.Errorf("'%s' should be %t, but %t!\n", filter, expected, got)
+		}
+	}
+}
+
+func TestFilter_Quoted(t *testing.T) {
+	h := &Host{
+		Name: "web (1)",
+		Loc:  "us east",
+		Tags: []string{`say "hi"`},
+	}
+
+	tests := map[string]bool{
+		`Name == "web (1)"`:                        true,
+		`Loc == 'us east'`:                         true,
+		`(Loc ~= "east")`:                          true,
+		`Tags == "say \"hi\""`:                     true,
+		`Name == web (1)`:                          false,
+		`(Name == "web (1)" and Loc == "us east")`: true,
+	}
+
+	for filter, expected := range tests {
+		f, err := ParseFilter(filter)
+		if err != nil {
+			if expected {
+				t.Errorf("'%s' should parse, but: %s\n", filter, err)
+			}
+			continue
+		}
+		if got := f.Match(h); got != expected {
+			t.Errorf("'%s' should be %t, but %t!\n", filter, expected, got)
+		}
+	}
+}
+
+func TestFilter_Errors(t *testing.T) {
+	tests := map[string]int{
+		"Tags == dev and":           16,
+		"Tags = dev":                6,
+		"Colour == red":             1,
+		"(Tags == dev":              1,
+		"Tags == dev)":              12,
+		"Tags == dev or or":         16,
+		"Port == ssh":               9,
+		`Name == "unterminated`:     9,
+		"Tags":                      5,
+		"Tags == dev Tags == httpd": 13,
+	}
+
+	for filter, col := range tests {
+		_, err := ParseFilter(filter)
+		if err == nil {
+			t.Errorf("'%s' should not parse, but did!\n", filter)
+			continue
+		}
+		fe, ok := err.(*FilterError)
+		if !ok {
+			t.Errorf("'%s' error should be a *FilterError, but is %T\n", filter, err)
+			continue
+		}
+		if fe.Column != col {
+			t.Errorf("'%s' error should be at column %d, but is at %d: %s\n", filter, col, fe.Column, fe)
+		}
+	}
+
+	if host2.If("Tags === dev") {
+		t.Error("Invalid filter should not match!")
+	}
+}
+
+func TestFilter_Empty(t *testing.T) {
+	f, err := ParseFilter("  ")
+	if err != nil {
+		t.Fatalf("Empty filter should parse, but: %s\n", err)
+	}
+	if !f.Match(host2) {
+		t.Error("Empty filter should match everything!")
+	}
+}
+
+func TestFilter_KeyValueTags(t *testing.T) {
+	h := &Host{
+		Tags:   []string{"owner", "legacy|yes"},
+		TagMap: map[string]string{"env": "prod", "role": "webserver"},
+	}
+
+	tests := map[string]bool{
+		"Tag[env] == prod":                      true,
+		"Tag[env] != prod":                      false,
+		"Tag[role] ~= web":                      true,
+		"Tag[role] =~ ^db":                      false,
+		"Tag[role] in [webserver, dbserver]":    true,
+		"Tag[legacy] == yes":                    true,
+		"has Tag[owner]":                        true,
+		"HAS Tag[env] and not has Tag[nope]":    true,
+		"has Tag[nope]":                         false,
+		"Tag[nope] == prod":                     false,
+		"Tag[nope] != prod":                     true,
+		"(has Tag[owner] and Tag[env] == prod)": true,
+	}
+
+	for filter, expected := range tests {
+		if got := h.If(filter); got != expected {
+			t.Errorf("'%s' should be %t, but %t!\n", filter, expected, got)
+		}
+	}
+
+	for filter, col := range map[string]int{"has Tags": 5, "Tag[] == x": 1, "has Tag[]": 5, "Tag[env] >= 2": 10} {
+		_, err := ParseFilter(filter)
+		if fe, ok := err.(*FilterError); !ok || fe.Column != col {
+			t.Errorf("'%s' should fail at column %d, but: %v\n", filter, col, err)
+		}
+	}
+}
+
+func TestFilter_Vars(t *testing.T) {
+	h := &Host{
+		Vars:   map[string]string{"datadir": "/data", "shards": "4"},
+		TagMap: map[string]string{"datadir": "/tagged"},
+	}
+
+	tests := map[string]bool{
+		"Var[datadir] == /data":               true,
+		"Var[datadir] glob /data*":            true,
+		"Var[shards] in [2, 4]":               true,
+		"has Var[datadir] and not has Var[x]": true,
+		"Tag[datadir] == /data":               false,
+		"Var[nope] == /data":                  false,
+	}
+
+	for filter, expected := range tests {
+		if got := h.If(filter); got != expected {
+			t.Errorf("'%s' should be %t, but %t!\n", filter, expected, got)
+		}
+	}
+}
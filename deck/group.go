@@ -0,0 +1,186 @@
+package deck
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// OutputGroup is every host whose output from a command, or workflow step, was identical
+type OutputGroup struct {
+	Workflow   string `json:",omitempty" xml:",omitempty"`
+	Step       int
+	Command    string
+	Hosts      []string `xml:"Hosts>Host"`
+	Stdout     []string
+	Stderr     []string
+	ErrorType  string `json:",omitempty" xml:",omitempty"`
+	ExitCode   int
+	ExitSignal string `json:",omitempty" xml:",omitempty"`
+
+	// order is the earliest position of the command in a Result
+	order int
+}
+
+// OutputGroups groups the CommandReturns of Results by command, and then by identical
+// output, like dshbak -c
+type OutputGroups struct {
+	XMLName xml.Name       `json:"-" xml:"OutputGroups"`
+	Groups  []*OutputGroup `xml:"Group"`
+
+	byKey map[string]*OutputGroup
+	lock  sync.Mutex
+}
+
+// Add groups the CommandReturns of the Result. QUIET ones are skipped.
+func (g *OutputGroups) Add(res Result) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.byKey == nil {
+		g.byKey = make(map[string]*OutputGroup)
+	}
+
+	for i := range res.CommandReturns {
+		cr := &res.CommandReturns[i]
+		if cr.Quiet {
+			continue
+		}
+
+		f := cr.format()
+		key := strings.Join([]string{
+			cr.Workflow, fmt.Sprint(cr.Step), cr.Command,
+			strings.Join(f.Stdout, "\n"), strings.Join(f.Stderr, "\n"),
+			cr.ErrorType, fmt.Sprint(cr.ExitCode), cr.ExitSignal,
+		}, "\x00")
+
+		og, ok := g.byKey[key]
+		if !ok {
+			og = &OutputGroup{
+				Workflow:   cr.Workflow,
+				Step:       cr.Step,
+				Command:    cr.Command,
+				Stdout:     f.Stdout,
+				Stderr:     f.Stderr,
+				ErrorType:  cr.ErrorType,
+				ExitCode:   cr.ExitCode,
+				ExitSignal: cr.ExitSignal,
+				order:      i,
+			}
+			g.byKey[key] = og
+			g.Groups = append(g.Groups, og)
+		} else if i < og.order {
+			og.order = i
+		}
+		og.Hosts = append(og.Hosts, res.Host.ID())
+	}
+}
+
+// sorted sorts the groups in the order the commands ran, and then by how many hosts
+// they have, most first, for stable output
+func (g *OutputGroups) sorted() {
+	for _, og := range g.Groups {
+		sort.Strings(og.Hosts)
+	}
+
+	sort.SliceStable(g.Groups, func(i, j int) bool {
+		a, b := g.Groups[i], g.Groups[j]
+		switch {
+		case a.Workflow != b.Workflow || a.Step != b.Step || a.Command != b.Command:
+			if a.order != b.order {
+				return a.order < b.order
+			} else if a.Workflow != b.Workflow {
+				return a.Workflow < b.Workflow
+			} else if a.Step != b.Step {
+				return a.Step < b.Step
+			}
+			return a.Command < b.Command
+		case len(a.Hosts) != len(b.Hosts):
+			return len(a.Hosts) > len(b.Hosts)
+		}
+		return a.Hosts[0] < b.Hosts[0]
+	})
+}
+
+// ToText returns the groups as text: Each command, and then each distinct output of
+// it, once, with the hosts that output it. If count is true, only the number of hosts
+// is output, instead of their names.
+func (g *OutputGroups) ToText(count bool) (out string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.sorted()
+
+	const rule = "----------------\n"
+	for i, og := range g.Groups {
+		if i == 0 || og.Workflow != g.Groups[i-1].Workflow || og.Step != g.Groups[i-1].Step || og.Command != g.Groups[i-1].Command {
+			if og.Workflow != "" {
+				out = out + fmt.Sprintf("== %s step %d: %s\n", og.Workflow, og.Step+1, og.Command)
+			} else {
+				out = out + fmt.Sprintf("== %s\n", og.Command)
+			}
+		}
+
+		out = out + rule
+		if count {
+			out = out + fmt.Sprintf("%d hosts\n", len(og.Hosts))
+		} else {
+			out = out + strings.Join(og.Hosts, ",") + "\n"
+		}
+		out = out + rule
+
+		for _, l := range og.Stdout {
+			out = out + l + "\n"
+		}
+		if len(og.Stderr) > 0 {
+			out = out + "STDERR:\n"
+			for _, l := range og.Stderr {
+				out = out + l + "\n"
+			}
+		}
+
+		switch {
+		case og.ErrorType == ErrorTimeout:
+			out = out + "TIMED OUT\n"
+		case og.ErrorType == ErrorCanceled:
+			out = out + "CANCELED\n"
+		case og.ErrorType == ErrorConnection:
+			out = out + "UNREACHABLE\n"
+		case og.ErrorType == ErrorSession:
+			out = out + "SESSION FAILED\n"
+		case og.ExitSignal != "":
+			out = out + "SIGNAL: " + og.ExitSignal + "\n"
+		case og.ExitCode != 0:
+			out = out + fmt.Sprintf("EXIT: %d\n", og.ExitCode)
+		}
+		out = out + "\n"
+	}
+	return
+}
+
+// ToJSON returns the groups as JSON
+func (g *OutputGroups) ToJSON(pretty bool) (j []byte) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.sorted()
+
+	// Only strings and ints, so it always marshals
+	if !pretty {
+		j, _ = json.Marshal(g)
+	} else {
+		j, _ = json.MarshalIndent(g, "", "\t")
+	}
+	return
+}
+
+// ToXML returns the groups as XML
+func (g *OutputGroups) ToXML() []byte {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.sorted()
+
+	x, _ := xml.Marshal(g)
+	return x
+}
@@ -0,0 +1,81 @@
+package deck
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestOutputGroups(t *testing.T) {
+	ret := func(host, stdout string, code int) Result {
+		cr := CommandReturn{HostObj: Host{Name: host}, Command: "uptime"}
+		cr.Stdout.WriteString(stdout)
+		if code != 0 {
+			cr.Error = fmt.Errorf("exit %d", code)
+			cr.ErrorType = ErrorExit
+			cr.ExitCode = code
+		}
+		return Result{Host: Host{Name: host}, CommandReturns: []CommandReturn{cr}}
+	}
+
+	var g OutputGroups
+	g.Add(ret("web03", "up\n", 0))
+	g.Add(ret("web01", "up\n", 0))
+	g.Add(ret("db01", "down\n", 1))
+	g.Add(ret("web02", "up\n", 0))
+
+	expected := "== uptime\n" +
+		"----------------\nweb01,web02,web03\n----------------\nup\n\n" +
+		"----------------\ndb01\n----------------\ndown\nEXIT: 1\n\n"
+	if out := g.ToText(false); out != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s\n", expected, out)
+	}
+
+	if out := g.ToText(true); !strings.Contains(out, "\n3 hosts\n") || !strings.Contains(out, "\n1 hosts\n") {
+		t.Errorf("Expected host counts, got:\n%s\n", out)
+	}
+}
+
+func TestOutputGroups_Workflow(t *testing.T) {
+	res := func(host string, outs ...string) Result {
+		r := Result{Host: Host{Name: host}}
+		for i, o := range outs {
+			cr := CommandReturn{HostObj: r.Host, Workflow: "wf", Step: i, Command: fmt.Sprintf("step%d", i)}
+			cr.Stdout.WriteString(o)
+			r.CommandReturns = append(r.CommandReturns, cr)
+		}
+		return r
+	}
+
+	var g OutputGroups
+	g.Add(res("b", "same\n", "b\n"))
+	g.Add(res("a", "same\n", "a\n"))
+
+	out := g.ToText(false)
+	if strings.Count(out, "== wf step") != 2 {
+		t.Errorf("Expected a heading per step, got:\n%s\n", out)
+	}
+	if !strings.Contains(out, "\na,b\n") {
+		t.Errorf("Expected identical first step grouped, got:\n%s\n", out)
+	}
+	if strings.Index(out, "step 1:") > strings.Index(out, "step 2:") {
+		t.Errorf("Expected steps in order, got:\n%s\n", out)
+	}
+	if len(g.Groups) != 3 {
+		t.Errorf("Expected 3 groups, got %d\n", len(g.Groups))
+	}
+}
+
+func TestOutputGroups_SameName(t *testing.T) {
+	var g OutputGroups
+	for _, addr := range []string{"10.0.0.2", "10.0.0.1"} {
+		h := Host{Name: "web", Address: addr}
+		cr := CommandReturn{HostObj: h, Command: "uptime"}
+		cr.Stdout.WriteString("up\n")
+		g.Add(Result{Host: h, CommandReturns: []CommandReturn{cr}})
+	}
+
+	if out := g.ToText(false); !strings.Contains(out, "\nweb/10.0.0.1,web/10.0.0.2\n") {
+		t.Errorf("Expected both hosts named web, told apart, got:\n%s\n", out)
+	}
+}
@@ -0,0 +1,236 @@
+package deck
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key checking modes
+const (
+	// HostKeyStrict requires every host key to already be known
+	HostKeyStrict = "strict"
+	// HostKeyTOFU trusts (and records) keys of unknown hosts, but rejects mismatches
+	HostKeyTOFU = "tofu"
+	// HostKeyOff disables host key checking, except for pinned keys
+	HostKeyOff = "off"
+)
+
+// HostKeyChecker verifies SSH host keys against known_hosts files,
+// and any fingerprint pinned to a Host
+type HostKeyChecker struct {
+	Mode  string
+	Files []string
+	// Debug, if set, is where newly trusted keys are logged
+	Debug *log.Logger
+
+	known    ssh.HostKeyCallback
+	accepted map[string]ssh.PublicKey
+	// probe is a key to look hosts up in known_hosts with
+	probe ssh.PublicKey
+	lock  sync.Mutex
+}
+
+// NewHostKeyChecker returns a HostKeyChecker for the specified mode, reading
+// the listed known_hosts files. The first is the default one: If it does not exist,
+// it is skipped, except in HostKeyTOFU mode, where it is created so new keys may
+// be recorded. The rest were asked for, so it is an error if they do not exist.
+func NewHostKeyChecker(mode string, files ...string) (*HostKeyChecker, error) {
+	k := &HostKeyChecker{
+		Mode:     mode,
+		accepted: make(map[string]ssh.PublicKey),
+	}
+
+	switch mode {
+	case HostKeyOff:
+		return k, nil
+	case HostKeyStrict, HostKeyTOFU:
+	default:
+		return nil, fmt.Errorf("host key checking mode must be one of %q, %q, or %q, not %q", HostKeyStrict, HostKeyTOFU, HostKeyOff, mode)
+	}
+
+	for i, f := range files {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+			if i > 0 {
+				return nil, fmt.Errorf("known_hosts file %s does not exist", f)
+			} else if mode != HostKeyTOFU {
+				// Skip it
+				continue
+			}
+			// We need somewhere to write new keys to
+			if err = os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+				return nil, err
+			}
+			nf, err := os.OpenFile(f, os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				return nil, err
+			}
+			nf.Close()
+		}
+		k.Files = append(k.Files, f)
+	}
+
+	known, err := knownhosts.New(k.Files...)
+	if err != nil {
+		return nil, err
+	}
+	k.known = known
+
+	return k, nil
+}
+
+// Callback returns an ssh.HostKeyCallback that verifies the key presented for
+// the specified Host
+func (k *HostKeyChecker) Callback(h Host) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if h.HostKey != "" {
+			// Pinned keys trump everything
+			keyType, pinned := h.pinnedKey()
+			if fp := ssh.FingerprintSHA256(key); fp != pinned || (keyType != "" && key.Type() != keyType) {
+				return fmt.Errorf("host key mismatch for %s: pinned %s, presented %s %s", hostname, h.HostKey, key.Type(), fp)
+			}
+			return nil
+		}
+
+		if k.Mode == HostKeyOff {
+			return nil
+		}
+
+		err := k.known(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		kerr, ok := err.(*knownhosts.KeyError)
+		if !ok {
+			// Revoked, or something worse
+			return fmt.Errorf("host key for %s rejected: %s", hostname, err)
+		} else if len(kerr.Want) > 0 {
+			var want []string
+			for _, w := range kerr.Want {
+				want = append(want, w.Key.Type()+" "+ssh.FingerprintSHA256(w.Key))
+			}
+			return fmt.Errorf("host key mismatch for %s: presented %s %s, but known_hosts has %s (possible MITM)", hostname, key.Type(), ssh.FingerprintSHA256(key), strings.Join(want, ", "))
+		} else if k.Mode != HostKeyTOFU {
+			return fmt.Errorf("host key for %s is unknown (%s %s), and host key checking is %s", hostname, key.Type(), ssh.FingerprintSHA256(key), k.Mode)
+		}
+
+		// POST: TOFU, and we've never seen this host before
+		return k.trust(hostname, key)
+	}
+}
+
+// hostKeyAlgorithms are the host key algorithms for each key type, in the order we prefer them
+var hostKeyAlgorithms = []struct {
+	keyType string
+	algos   []string
+}{
+	{ssh.KeyAlgoED25519, []string{ssh.KeyAlgoED25519}},
+	{ssh.KeyAlgoECDSA256, []string{ssh.KeyAlgoECDSA256}},
+	{ssh.KeyAlgoECDSA384, []string{ssh.KeyAlgoECDSA384}},
+	{ssh.KeyAlgoECDSA521, []string{ssh.KeyAlgoECDSA521}},
+	{ssh.KeyAlgoSKED25519, []string{ssh.KeyAlgoSKED25519}},
+	{ssh.KeyAlgoSKECDSA256, []string{ssh.KeyAlgoSKECDSA256}},
+	{ssh.KeyAlgoRSA, []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}},
+	{ssh.KeyAlgoDSA, []string{ssh.KeyAlgoDSA}},
+}
+
+// Algorithms returns the host key algorithms to ask the Host for, for
+// ssh.ClientConfig.HostKeyAlgorithms: Those of its pinned key, or of the keys
+// known_hosts has for it, so the key we're sent is one we can check. nil (the
+// defaults) if the pinned key has no type, checking is off, or known_hosts has none.
+func (k *HostKeyChecker) Algorithms(h Host) []string {
+	if h.HostKey != "" {
+		keyType, _ := h.pinnedKey()
+		return algorithmsFor(map[string]bool{keyType: true})
+	} else if k.Mode == HostKeyOff || k.known == nil {
+		return nil
+	}
+
+	k.lock.Lock()
+	if k.probe == nil {
+		// Never in known_hosts, so the KeyError lists every key that is
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err == nil {
+			k.probe, err = ssh.NewPublicKey(pub)
+		}
+		if err != nil {
+			k.lock.Unlock()
+			return nil
+		}
+	}
+	probe := k.probe
+	k.lock.Unlock()
+
+	// The address is only used if the hostname can't be
+	anyAddr := &net.TCPAddr{IP: net.IPv4zero}
+	kerr, ok := k.known(h.ConnectAddress(), anyAddr, probe).(*knownhosts.KeyError)
+	if !ok || len(kerr.Want) == 0 {
+		return nil
+	}
+
+	types := make(map[string]bool)
+	for _, w := range kerr.Want {
+		types[w.Key.Type()] = true
+	}
+	return algorithmsFor(types)
+}
+
+// algorithmsFor returns the host key algorithms for the key types, in the order we
+// prefer them
+func algorithmsFor(types map[string]bool) (algos []string) {
+	for _, a := range hostKeyAlgorithms {
+		if types[a.keyType] {
+			algos = append(algos, a.algos...)
+		}
+	}
+	return
+}
+
+// trust records the key for hostname, both for the remainder of this run, and in the
+// first known_hosts file
+func (k *HostKeyChecker) trust(hostname string, key ssh.PublicKey) error {
+	addr := knownhosts.Normalize(hostname)
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if prev, ok := k.accepted[addr]; ok {
+		// Already trusted this run, so it must match
+		if string(prev.Marshal()) != string(key.Marshal()) {
+			return fmt.Errorf("host key mismatch for %s: presented %s, but %s was trusted earlier in this run", hostname, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(prev))
+		}
+		return nil
+	}
+
+	if len(k.Files) > 0 {
+		f, err := os.OpenFile(k.Files[0], os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("cannot record host key for %s: %s", hostname, err)
+		}
+		defer f.Close()
+
+		if _, err = fmt.Fprintln(f, knownhosts.Line([]string{addr}, key)); err != nil {
+			return fmt.Errorf("cannot record host key for %s: %s", hostname, err)
+		}
+	}
+
+	logf(k.Debug, "Trusting new %s host key for %s: %s\n", key.Type(), hostname, ssh.FingerprintSHA256(key))
+	k.accepted[addr] = key
+	return nil
+}
@@ -0,0 +1,137 @@
+package deck
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var hkAddr = &net.TCPAddr{IP: net.ParseIP("10.0.2.1"), Port: 22}
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeys_BadMode(t *testing.T) {
+	if _, err := NewHostKeyChecker("sometimes"); err == nil {
+		t.Error("Expected error for bogus mode, got nil")
+	}
+}
+
+func TestHostKeys_StrictUnknown(t *testing.T) {
+	kh := filepath.Join(t.TempDir(), "known_hosts")
+
+	k, err := NewHostKeyChecker(HostKeyStrict, kh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = k.Callback(Host{})("10.0.2.1:22", hkAddr, newTestHostKey(t)); err == nil {
+		t.Error("Expected strict to reject unknown key, but it didn't")
+	}
+}
+
+func TestHostKeys_MissingFile(t *testing.T) {
+	dir := t.TempDir()
+	kh := filepath.Join(dir, "known_hosts")
+	if err := ioutil.WriteFile(kh, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []string{HostKeyStrict, HostKeyTOFU} {
+		if _, err := NewHostKeyChecker(mode, kh, filepath.Join(dir, "known_hsots")); err == nil {
+			t.Errorf("Expected %s to fail on a missing additional file, got nil\n", mode)
+		}
+		if _, err := NewHostKeyChecker(mode, filepath.Join(dir, mode, "known_hosts"), kh); err != nil {
+			t.Errorf("Expected %s to tolerate a missing default file, got: %s\n", mode, err)
+		}
+	}
+}
+
+func TestHostKeys_TOFU(t *testing.T) {
+	kh := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	key := newTestHostKey(t)
+
+	k, err := NewHostKeyChecker(HostKeyTOFU, kh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cb := k.Callback(Host{})
+	if err = cb("10.0.2.1:22", hkAddr, key); err != nil {
+		t.Errorf("Expected TOFU to accept unknown key, got: %s\n", err)
+	}
+	if err = cb("10.0.2.1:22", hkAddr, key); err != nil {
+		t.Errorf("Expected TOFU to re-accept trusted key, got: %s\n", err)
+	}
+	if err = cb("10.0.2.1:22", hkAddr, newTestHostKey(t)); err == nil {
+		t.Error("Expected TOFU to reject a changed key, but it didn't")
+	}
+
+	buf, err := ioutil.ReadFile(kh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(buf), "10.0.2.1 ssh-ed25519 ") {
+		t.Errorf("Expected key recorded in known_hosts, got '%s'\n", buf)
+	}
+
+	// A fresh, strict, checker should now know the key
+	k, err = NewHostKeyChecker(HostKeyStrict, kh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb = k.Callback(Host{})
+	if err = cb("10.0.2.1:22", hkAddr, key); err != nil {
+		t.Errorf("Expected strict to accept recorded key, got: %s\n", err)
+	}
+	if err = cb("10.0.2.1:22", hkAddr, newTestHostKey(t)); err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Errorf("Expected strict to reject mismatched key, got: %v\n", err)
+	}
+}
+
+func TestHostKeys_Pinned(t *testing.T) {
+	key := newTestHostKey(t)
+
+	k, err := NewHostKeyChecker(HostKeyOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := Host{HostKey: ssh.FingerprintSHA256(key)}
+	if err = k.Callback(h)("10.0.2.1:22", hkAddr, key); err != nil {
+		t.Errorf("Expected pinned key to be accepted, got: %s\n", err)
+	}
+	if err = k.Callback(h)("10.0.2.1:22", hkAddr, newTestHostKey(t)); err == nil {
+		t.Error("Expected pinned key to reject other key, even when checking is off")
+	}
+	if err = k.Callback(Host{})("10.0.2.1:22", hkAddr, newTestHostKey(t)); err != nil {
+		t.Errorf("Expected off to accept anything unpinned, got: %s\n", err)
+	}
+
+	h = Host{HostKey: "ssh-ed25519 " + ssh.FingerprintSHA256(key)}
+	if err = k.Callback(h)("10.0.2.1:22", hkAddr, key); err != nil {
+		t.Errorf("Expected pinned key, with its type, to be accepted, got: %s\n", err)
+	}
+	if algos := k.Algorithms(h); len(algos) != 1 || algos[0] != ssh.KeyAlgoED25519 {
+		t.Errorf("Expected only %s to be asked for, got %v\n", ssh.KeyAlgoED25519, algos)
+	}
+	h = Host{HostKey: "ssh-rsa " + ssh.FingerprintSHA256(key)}
+	if err = k.Callback(h)("10.0.2.1:22", hkAddr, key); err == nil {
+		t.Error("Expected pinned key of another type to be rejected, but it wasn't")
+	}
+}
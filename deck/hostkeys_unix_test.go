@@ -0,0 +1,77 @@
+//go:build !windows && !plan9
+
+package deck
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestHostKeys_Algorithms(t *testing.T) {
+	s := newTestSSHServer(t)
+	ecKey, edKey := s.HostKeys[0], s.HostKeys[1]
+
+	// Only the ed25519 key is known, and the server would rather send its ECDSA one
+	kh := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.Host.ConnectAddress())}, edKey)
+	if err := ioutil.WriteFile(kh, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := NewHostKeyChecker(HostKeyStrict, kh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if algos := k.Algorithms(s.Host); len(algos) != 1 || algos[0] != ssh.KeyAlgoED25519 {
+		t.Errorf("Expected only %s, got %v\n", ssh.KeyAlgoED25519, algos)
+	}
+	if algos := k.Algorithms(Host{Address: "10.9.9.9"}); algos != nil {
+		t.Errorf("Expected defaults for an unknown host, got %v\n", algos)
+	}
+
+	com := s.Command("true")
+	com.SSHConfig.HostKeyCallback = k.Callback(s.Host)
+	com.SSHConfig.HostKeyAlgorithms = k.Algorithms(s.Host)
+	if cr := com.Exec(); cr.Error != nil {
+		t.Errorf("Expected the known ed25519 key to be asked for, and accepted, got: %s\n", cr.Error)
+	}
+
+	// Without asking for it, we get the ECDSA key, which is reported against what's known
+	com.SSHConfig.HostKeyAlgorithms = nil
+	cr := com.Exec()
+	if cr.Error == nil {
+		t.Fatal("Expected the unknown ECDSA key to be rejected, but it wasn't")
+	}
+	want := fmt.Sprintf("presented %s %s, but known_hosts has %s %s", ecKey.Type(), ssh.FingerprintSHA256(ecKey), edKey.Type(), ssh.FingerprintSHA256(edKey))
+	if !strings.Contains(cr.Error.Error(), want) {
+		t.Errorf("Expected '%s' in the error, got: %s\n", want, cr.Error)
+	}
+}
+
+func TestHostKeys_PinnedAlgorithms(t *testing.T) {
+	s := newTestSSHServer(t)
+
+	k, err := NewHostKeyChecker(HostKeyStrict, filepath.Join(t.TempDir(), "known_hosts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Whichever key is pinned is the one asked for
+	for _, key := range s.HostKeys {
+		h := s.Host
+		h.HostKey = key.Type() + " " + ssh.FingerprintSHA256(key)
+
+		com := s.Command("true")
+		com.SSHConfig.HostKeyCallback = k.Callback(h)
+		com.SSHConfig.HostKeyAlgorithms = k.Algorithms(h)
+		if cr := com.Exec(); cr.Error != nil {
+			t.Errorf("Expected the pinned %s key to be accepted, got: %s\n", key.Type(), cr.Error)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+//go:build go1.4
+
+package deck
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Host is a structure to capture properties of individual systems
+type Host struct {
+	Address            string
+	Arch               string
+	Loc                string
+	Wave               int
+	Name               string
+	Offline            bool
+	Port               int
+	Tags               []string
+	TagMap             map[string]string
+	Vars               map[string]string
+	User               string
+	DontUpdatePackages string
+	HostKey            string
+	Jump               string
+}
+
+// ConnectAddress returns the "address:port" to connect to the Host on
+func (h *Host) ConnectAddress() string {
+	connectName := h.Address
+	if connectName == "" {
+		connectName = h.Name
+	}
+
+	port := "22"
+	if h.Port != 0 {
+		port = strconv.Itoa(h.Port)
+	}
+
+	return net.JoinHostPort(connectName, port)
+}
+
+// ID identifies the Host, even among others sharing its Name: its name, and the
+// address it's connected to (with the port, if it isn't 22), e.g. "web01/10.0.0.1".
+// Just the name, if it is the address.
+func (h *Host) ID() string {
+	return hostID(h.Name, h.ConnectAddress())
+}
+
+// hostID is Host.ID, of the name, and the "address:port" connected to
+func hostID(name, address string) string {
+	address = strings.TrimSuffix(address, ":22")
+	if address == "" || address == name {
+		return name
+	} else if name == "" {
+		return address
+	}
+	return name + "/" + address
+}
+
+// pinnedKey returns the type, if it has one, and fingerprint of the Host's pinned
+// HostKey, which is either "SHA256:..." or "<type> SHA256:..."
+func (h *Host) pinnedKey() (keyType, fingerprint string) {
+	if f := strings.Fields(h.HostKey); len(f) == 2 {
+		return f[0], f[1]
+	}
+	return "", strings.TrimSpace(h.HostKey)
+}
+
+// JumpChain returns the ordered list of jump hosts to connect to the Host through,
+// using defaultJump if the Host doesn't specify its own. A Jump of "none" means
+// to connect directly, regardless of defaultJump.
+func (h *Host) JumpChain(defaultJump string) (chain []string) {
+	jump := h.Jump
+	if jump == "" {
+		jump = defaultJump
+	}
+	if jump == "" || jump == "none" {
+		return
+	}
+
+	for _, j := range strings.Split(jump, ",") {
+		if j = strings.TrimSpace(j); j != "" {
+			chain = append(chain, j)
+		}
+	}
+	return
+}
+
+// SortTags sorts the Host's Tags array in alphanumeric order
+func (h *Host) SortTags() {
+	if !sort.StringsAreSorted(h.Tags) {
+		sort.Strings(h.Tags)
+	}
+}
+
+// Tag returns the value of the key/value tag, and whether the Host has it at all.
+// TagMap is checked first, then Tags, where "key|value" is a key/value tag, and a
+// bare "key" is a tag with an empty value.
+func (h *Host) Tag(key string) (string, bool) {
+	if v, ok := h.TagMap[key]; ok {
+		return v, true
+	}
+
+	for _, t := range h.Tags {
+		if t == key {
+			return "", true
+		} else if strings.HasPrefix(t, key+"|") {
+			return t[len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// Var returns the value of the named host variable, and whether it is set at all.
+// DontUpdatePackages is the "dontupdatepackages" var, unless Vars has its own.
+func (h *Host) Var(name string) (string, bool) {
+	if v, ok := h.Vars[name]; ok {
+		return v, true
+	}
+
+	if name == "dontupdatepackages" && h.DontUpdatePackages != "" {
+		return h.DontUpdatePackages, true
+	}
+	return "", false
+}
+
+// SearchTags iterates over the Tags array and return true/false if the requested tag is found
+func (h *Host) SearchTags(tag string, fuzzy bool) bool {
+	for _, t := range h.Tags {
+		if !fuzzy && t == tag {
+			return true
+		} else if fuzzy && strings.Contains(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+If takes a condition list ("filter") and applies it to the Host. Filters are parsed
+once, and cached. See Filter for the syntax.
+
+	Tags == dev and (Tags == httpd or Tags == haproxy) and not Name == daisy
+*/
+func (h *Host) If(cond string) bool {
+	if cond == "" {
+		return true
+	}
+
+	f, err := cachedFilter(cond)
+	if err != nil {
+		// Config.Check and Runner.Check catch these
+		return false
+	}
+	return f.Match(h)
+}
+
+// And returns true if all of the conditions are true
+func (h *Host) And(conds []string) bool {
+	for _, a := range conds {
+		ret := h.If(a)
+		if !ret {
+			return false
+		}
+	}
+	return true
+}
+
+// Or returns true if any of the conditions are true
+func (h *Host) Or(conds []string) bool {
+	for _, o := range conds {
+		ret := h.If(o)
+		if ret {
+			return true
+		}
+	}
+	return false
+}
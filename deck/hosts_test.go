@@ -0,0 +1,284 @@
+package deck
+
+import (
+	"sort"
+	"testing"
+)
+
+var host1 = &Host{
+	Address: "1.2.3.4",
+	Arch:    "x86_64",
+	Loc:     "east",
+	Name:    "testhost1",
+	Wave:    1,
+	Offline: false,
+	Port:    22,
+	Tags:    []string{"tag3", "tag2", "tag1", "tag4", "NOPEFUZZY"},
+	User:    "auser",
+}
+
+// Tags == dev and Tags == httpd or Tags == haproxy or Tags == tomcat and Tags == daisy
+var host2 = &Host{
+	Tags: []string{"dev", "httpd", "tomcat", "daisy"},
+}
+
+func TestHost_Wave(t *testing.T) {
+	if host1.If("Wave != 1") {
+		t.Error("Wave not evaluated properly")
+	}
+}
+
+func TestHost_TagSort(t *testing.T) {
+	if sort.StringsAreSorted(host1.Tags) == true {
+		t.Error("Tags should not be sorted already, but are!")
+	}
+
+	host1.SortTags()
+	if sort.StringsAreSorted(host1.Tags) == false {
+		t.Error("Tags should be sorted, but aren't: ", host1.Tags)
+	}
+}
+
+func TestHost_TagSearch(t *testing.T) {
+	if host1.SearchTags("tag2", false) == false {
+		t.Error("Expecting to find tag2, but didn't: ", host1.Tags)
+	}
+
+	if host1.SearchTags("NOPE", false) {
+		t.Error("Not expecting to find NOPE, but did: ", host1.Tags)
+	}
+}
+
+func TestHost_TagSearchFuzzy(t *testing.T) {
+	if host1.SearchTags("tag2", true) == false {
+		t.Error("Expecting to find tag2, but didn't: ", host1.Tags)
+	}
+
+	if host1.SearchTags("NOPE", true) == false {
+		t.Error("Expecting to find fuzzy NOPE, but didn't: ", host1.Tags)
+	}
+}
+
+func TestHost_SimpleFilters(t *testing.T) {
+	ap := host1.If("Address == 1.2.3.4")
+	an := host1.If("Address != 1.2.3.4")
+	if ap == false {
+		t.Error("Address == 1.2.3.4 should be true, but false!")
+	}
+	if an {
+		t.Error("Address != 1.2.3.4 should be false, but true!")
+	}
+
+	pp := host1.If("Port == 22")
+	pn := host1.If("Port != 22")
+	if pp == false {
+		t.Error("Port == 22 should be true, but false!")
+	}
+	if pn {
+		t.Error("Port != 22 should be false, but true!")
+	}
+
+	tp := host1.If("Tags == tag1")
+	tn := host1.If("Tags != tag1")
+	if tp == false {
+		t.Error("Tags == tag1 should be true, but false!")
+	}
+	if tn {
+		t.Error("Tags != tag1 should be false, but true!")
+	}
+
+	andp := host1.If("Tags == tag1 and Address == 1.2.3.4")
+	andn := host1.If("Tags == NOPE and Address == 1.2.3.4")
+	if andp == false {
+		t.Error("Tags == tag1 and Address == 1.2.3.4 should be true, but false!")
+	}
+	if andn {
+		t.Error("Tags == NOPE and Address == 1.2.3.4 should be false, but true!")
+	}
+
+	andp = host1.If("Tags == tag1 && Address == 1.2.3.4")
+	andn = host1.If("Tags == NOPE && Address == 1.2.3.4")
+	if andp == false {
+		t.Error("Tags == tag1 && Address == 1.2.3.4 should be true, but false!")
+	}
+	if andn {
+		t.Error("Tags == NOPE && Address == 1.2.3.4 should be false, but true!")
+	}
+
+	andp = host1.If("Tags == tag1 AND Address == 1.2.3.4")
+	andn = host1.If("Tags == NOPE AND Address == 1.2.3.4")
+	if andp == false {
+		t.Error("Tags == tag1 AND Address == 1.2.3.4 should be true, but false!")
+	}
+	if andn {
+		t.Error("Tags == NOPE AND Address == 1.2.3.4 should be false, but true!")
+	}
+
+	orp := host1.If("Tags == NOPE or Address == 1.2.3.4")
+	orn := host1.If("Tags == NOPE or Address == NOPE")
+	if orp == false {
+		t.Error("Tags == NOPE or Address == 1.2.3.4 should be true, but false!")
+	}
+	if orn {
+		t.Error("Tags == NOPE or Address == NOPE should be false, but true!")
+	}
+}
+
+func TestHost_OperatorFilters(t *testing.T) {
+	tests := map[string]bool{
+		"Name =~ ^testhost[0-9]+$":               true,
+		"Name =~ ^web-[0-9]+-prod$":              false,
+		"Name !~ ^web-[0-9]+-prod$":              true,
+		"Tags =~ ^tag[3-9]$":                     true,
+		`Address =~ "^1\.2\."`:                   true,
+		"Name glob test*":                        true,
+		"Name glob test?ost[0-9]":                true,
+		"Name glob web-*":                        false,
+		"Name !glob web-*":                       true,
+		"Tags glob NOPE*":                        true,
+		"Wave >= 1":                              true,
+		"Wave > 1":                               false,
+		"Wave < 2":                               true,
+		"Wave <= 0":                              false,
+		"Port != 22":                             false,
+		"Port > 1024":                            false,
+		"Port <= 22":                             true,
+		"Loc in [east, west]":                    true,
+		"Loc in [north,south]":                   false,
+		"Tags in [nope, tag2]":                   true,
+		"Port in [22, 2222]":                     true,
+		"Wave in [2,3]":                          false,
+		"Name in ['a b', testhost1]":             true,
+		"Name in [(x), testhost1] and Wave >= 1": true,
+		"not Loc in [east]":                      false,
+	}
+
+	for filter, expected := range tests {
+		if got := host1.If(filter); got != expected {
+			t.Errorf("'%s' should be %t, but %t!\n", filter, expected, got)
+		}
+	}
+
+	// Wave 0 is no wave, and never matches
+	if (&Host{}).If("Wave < 2") {
+		t.Error("Wave < 2 should be false for a host with no wave, but true!")
+	}
+	// Port defaults to 22
+	if !(&Host{}).If("Port in [22]") {
+		t.Error("Port in [22] should be true for a host with no port, but false!")
+	}
+
+	for _, bad := range []string{"Name =~ web-[", "Name glob web-[", "Name > 2", "Wave in [1, two]", "Loc in [east", "Loc in east"} {
+		if _, err := ParseFilter(bad); err == nil {
+			t.Errorf("'%s' should not parse, but did!\n", bad)
+		}
+	}
+}
+
+func TestHost2_ComplexFilter(t *testing.T) {
+	f := "Tags == dev and Tags == httpd or Tags == haproxy or Tags == tomcat and Tags == daisy"
+	fn := "Tags == dev and Tags == httpd or Tags == haproxy or Tags == tomcat and Tags == dipsy"
+	fo := "Tags == dev and Tags == NOPE or Tags == NOT or Tags == HRM and Tags == daisy"
+
+	if host2.If(f) == false {
+		t.Errorf("Expecting true for '%s' from %s\n", f, host2.Tags)
+	}
+	if host2.If(fn) {
+		t.Errorf("Expecting false for '%s' from %s\n", fn, host2.Tags)
+	}
+	if host2.If(fo) {
+		t.Errorf("Expecting false for '%s' from %s\n", fo, host2.Tags)
+	}
+}
+
+func TestHost_ConnectAddress(t *testing.T) {
+	if a := host1.ConnectAddress(); a != "1.2.3.4:22" {
+		t.Errorf("Expected '1.2.3.4:22', got '%s'\n", a)
+	}
+
+	h := Host{Name: "somehost"}
+	if a := h.ConnectAddress(); a != "somehost:22" {
+		t.Errorf("Expected 'somehost:22', got '%s'\n", a)
+	}
+}
+
+func TestHost_ID(t *testing.T) {
+	for _, c := range []struct {
+		host Host
+		id   string
+	}{
+		{Host{Name: "web01", Address: "10.0.0.1"}, "web01/10.0.0.1"},
+		{Host{Name: "web01", Address: "10.0.0.1", Port: 2222}, "web01/10.0.0.1:2222"},
+		{Host{Name: "web01.example.com"}, "web01.example.com"},
+		{Host{Name: "10.0.0.1", Address: "10.0.0.1"}, "10.0.0.1"},
+		{Host{Address: "fe80::1"}, "[fe80::1]"},
+	} {
+		if id := c.host.ID(); id != c.id {
+			t.Errorf("Expected '%s' for %+v, got '%s'\n", c.id, c.host, id)
+		}
+	}
+}
+
+func TestHost_JumpChain(t *testing.T) {
+	h := Host{}
+	if c := h.JumpChain(""); len(c) != 0 {
+		t.Errorf("Expected no jump chain, got %v\n", c)
+	}
+	if c := h.JumpChain("bastion"); !stringArrayEquality(c, []string{"bastion"}) {
+		t.Errorf("Expected default jump chain, got %v\n", c)
+	}
+
+	h.Jump = "bastion1, admin@bastion2:2222"
+	if c := h.JumpChain("bastion"); !stringArrayEquality(c, []string{"bastion1", "admin@bastion2:2222"}) {
+		t.Errorf("Expected host jump chain, got %v\n", c)
+	}
+
+	h.Jump = "none"
+	if c := h.JumpChain("bastion"); len(c) != 0 {
+		t.Errorf("Expected 'none' to override the default, got %v\n", c)
+	}
+}
+
+func TestHost_Tag(t *testing.T) {
+	h := Host{
+		Tags:   []string{"owner", "env|dev", "role|web|frontend"},
+		TagMap: map[string]string{"env": "prod"},
+	}
+
+	tests := []struct {
+		key   string
+		value string
+		ok    bool
+	}{
+		{"env", "prod", true},
+		{"owner", "", true},
+		{"role", "web|frontend", true},
+		{"own", "", false},
+		{"nope", "", false},
+	}
+
+	for _, test := range tests {
+		v, ok := h.Tag(test.key)
+		if v != test.value || ok != test.ok {
+			t.Errorf("Tag(%s) expected '%s', %t, got '%s', %t\n", test.key, test.value, test.ok, v, ok)
+		}
+	}
+}
+
+func TestHost_Var(t *testing.T) {
+	h := Host{DontUpdatePackages: "kernel*"}
+	if v, ok := h.Var("dontupdatepackages"); !ok || v != "kernel*" {
+		t.Errorf("Expected DontUpdatePackages as a var, got '%s', %t\n", v, ok)
+	}
+	if _, ok := h.Var("datadir"); ok {
+		t.Error("Expected datadir to not be set")
+	}
+
+	h.Vars = map[string]string{"datadir": "/data", "dontupdatepackages": "php*"}
+	if v, ok := h.Var("datadir"); !ok || v != "/data" {
+		t.Errorf("Expected datadir '/data', got '%s', %t\n", v, ok)
+	}
+	if v, _ := h.Var("dontupdatepackages"); v != "php*" {
+		t.Errorf("Expected Vars to trump DontUpdatePackages, got '%s'\n", v)
+	}
+}
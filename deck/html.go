@@ -0,0 +1,125 @@
+package deck
+
+import (
+	"html/template"
+	"io"
+	"sync"
+	"time"
+)
+
+// htmlTemplate is the page an HTMLReport writes. Everything is inline, so it stands alone.
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"seconds": fmtSeconds,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>All Hands On Deck: {{with .Workflow}}{{.}}{{else}}{{.Command}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #eee; }
+pre { background: #f6f6f6; padding: 0.5em; margin: 0.3em 0; white-space: pre-wrap; }
+details { margin: 0.2em 0; }
+.succeeded { color: #080; }
+.failed, .unreachable, .timedout { color: #c00; font-weight: bold; }
+.skipped { color: #888; }
+.failures { border: 2px solid #c00; padding: 0 1em; margin-bottom: 1em; }
+.host { margin-bottom: 1.5em; }
+</style>
+</head>
+<body>
+<h1>All Hands On Deck: {{with .Workflow}}workflow {{.}}{{else}}{{.Command}}{{end}}</h1>
+
+{{with .Failures}}<div class="failures">
+<h2>Failures</h2>
+<table>
+<tr><th>Host</th><th>Status</th><th>Step</th><th>Command</th><th>Error</th></tr>
+{{range .}}<tr><td><a href="#host-{{.ID}}">{{.ID}}</a></td><td class="{{.Status}}">{{.Status}}</td>{{with .Failed}}<td>{{if .Step}}{{.Step}}{{end}}</td><td><code>{{.Command}}</code></td><td>{{.Error}}</td>{{else}}<td></td><td></td><td></td>{{end}}</tr>
+{{end}}</table>
+</div>
+{{end}}
+<h2>Run</h2>
+<table>
+<tr><th>Run</th><td>{{.RunID}}</td></tr>
+{{with .Workflow}}<tr><th>Workflow</th><td>{{.}}</td></tr>
+{{end}}{{with .Command}}<tr><th>Command</th><td><code>{{.}}</code></td></tr>
+{{end}}<tr><th>Filter</th><td>{{with .Filter}}<code>{{.}}</code>{{else}}none{{end}}</td></tr>
+<tr><th>Started</th><td>{{.Started.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Ended</th><td>{{.Ended.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Hosts targeted</th><td>{{len .Hosts}}: {{range $i, $h := .Hosts}}{{if $i}}, {{end}}{{$h.Name}}{{end}}</td></tr>
+{{with .Summary}}<tr><th>Summary</th><td><span class="succeeded">{{len .Succeeded}} succeeded</span>, <span class="failed">{{len .Failed}} failed</span>, <span class="unreachable">{{len .Unreachable}} unreachable</span>, <span class="timedout">{{len .TimedOut}} timed out</span>, <span class="skipped">{{len .Skipped}} skipped</span> (exit {{.ExitCode}})</td></tr>
+{{end}}</table>
+
+<h2>Hosts</h2>
+{{range .Results}}<div class="host" id="host-{{.ID}}">
+<h3>{{.Host.Name}}{{with .Host.Address}} ({{.}}){{end}}: <span class="{{.Status}}">{{.Status}}</span>{{if .Commands}} in {{seconds .Seconds}}{{end}}</h3>
+{{with .Commands}}<table>
+<tr><th>Step</th><th>Command</th><th>Status</th><th>Duration</th><th>Output</th></tr>
+{{range .}}<tr><td>{{if .Step}}{{with .Workflow}}{{.}} {{end}}{{.Step}}{{end}}</td><td><code>{{.Command}}</code></td><td class="{{.Status}}">{{.Status}}{{with .Error}}<br>{{.}}{{end}}</td><td>{{seconds .Seconds}}</td><td>{{with .Stdout}}<details><summary>stdout</summary><pre>{{.}}</pre></details>{{end}}{{with .Stderr}}<details><summary>stderr</summary><pre>{{.}}</pre></details>{{end}}</td></tr>
+{{end}}</table>
+{{end}}</div>
+{{end}}
+</body>
+</html>
+`))
+
+// htmlData is what htmlTemplate is executed with
+type htmlData struct {
+	TemplateData
+	Filter string
+	Hosts  []Host
+	// Failures are the Results of the hosts that failed, were unreachable, or timed out
+	Failures []TemplateResult
+}
+
+// HTMLReport is a single, static HTML page summarizing a run: The filter used, the hosts
+// targeted, every command on every host with its status, duration, and (collapsed)
+// output, and the failures first.
+type HTMLReport struct {
+	// Filter is the filter the hosts were chosen by
+	Filter string
+	// Hosts are the hosts targeted
+	Hosts []Host
+
+	results []Result
+	lock    sync.Mutex
+}
+
+// NewHTMLReport returns an HTMLReport of the run on the hosts, chosen by the filter
+func NewHTMLReport(filter string, hosts []Host) *HTMLReport {
+	return &HTMLReport{Filter: filter, Hosts: hosts}
+}
+
+// Add adds the Result to the HTMLReport
+func (h *HTMLReport) Add(res Result) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.results = append(h.results, res)
+}
+
+// Write writes the HTMLReport, of the RunState and Summary, and every Result added, to w
+func (h *HTMLReport) Write(w io.Writer, state *RunState, summary *Summary) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	data := htmlData{
+		TemplateData: newTemplateData(state, summary, h.results),
+		Filter:       h.Filter,
+		Hosts:        h.Hosts,
+	}
+	for _, tr := range data.Results {
+		if tr.Status != StatusSucceeded && tr.Status != StatusSkipped {
+			data.Failures = append(data.Failures, tr)
+		}
+	}
+
+	return htmlTemplate.Execute(w, data)
+}
+
+// fmtSeconds returns the seconds as a duration, to the millisecond
+func fmtSeconds(secs float64) string {
+	return time.Duration(secs * float64(time.Second)).Round(time.Millisecond).String()
+}
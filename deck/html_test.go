@@ -0,0 +1,54 @@
+package deck
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTMLReport(t *testing.T) {
+	hosts := []Host{{Name: "web01"}, {Name: "web02"}, {Name: "web03"}}
+	h := NewHTMLReport("Tags == web", hosts)
+
+	ok := CommandReturn{HostObj: hosts[0], Workflow: "wf", Command: "echo <hi>", Start: time.Now()}
+	ok.End = ok.Start.Add(1500 * time.Millisecond)
+	ok.Stdout.WriteString("<hi>\n")
+	bad := CommandReturn{HostObj: hosts[1], Workflow: "wf", Command: "false", Error: fmt.Errorf("exit 1"), ErrorType: ErrorExit, ExitCode: 1}
+
+	h.Add(Result{Host: hosts[0], Status: StatusSucceeded, CommandReturns: []CommandReturn{ok}})
+	h.Add(Result{Host: hosts[1], Status: StatusFailed, Step: 1, CommandReturns: []CommandReturn{bad}})
+	h.Add(Result{Host: hosts[2], Status: StatusSkipped})
+
+	summary := &Summary{}
+	summary.Add("web01", StatusSucceeded)
+	summary.Add("web02", StatusFailed)
+	summary.Add("web03", StatusSkipped)
+
+	var out bytes.Buffer
+	if err := h.Write(&out, &RunState{ID: "run1", Workflow: "wf"}, summary); err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+	page := out.String()
+
+	for _, want := range []string{
+		"<code>Tags == web</code>",
+		"3: web01, web02, web03",
+		`<pre>&lt;hi&gt;</pre>`,
+		"in 1.5s",
+		`<td class="failed">failed<br>exit 1</td>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected '%s' in the page, got:\n%s\n", want, page)
+		}
+	}
+
+	failures := strings.Index(page, `<div class="failures">`)
+	if failures < 0 || failures > strings.Index(page, "<h2>Hosts</h2>") {
+		t.Error("Expected the failures first")
+	}
+	if strings.Count(page[failures:strings.Index(page, "<h2>Run</h2>")], "<a href=") != 1 {
+		t.Error("Expected only web02 in the failures")
+	}
+}
@@ -0,0 +1,216 @@
+package deck
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// JumpHosts dials hosts through chains of intermediate "jump" (bastion) hosts,
+// sharing each bastion connection across every target host in a run
+type JumpHosts struct {
+	// SSHConfig returns the client config to use when connecting to the given jump Host
+	SSHConfig func(Host) *ssh.ClientConfig
+	// Hosts are the configured Hosts, which jumps may refer to by Name
+	Hosts []Host
+	// Debug, if set, is where reconnections are logged
+	Debug *log.Logger
+
+	clients map[string]*ssh.Client
+	dialing map[string]*jumpDial
+	lock    sync.Mutex
+}
+
+// jumpDial is a connection to a jump host being dialed, for everyone who needs it
+type jumpDial struct {
+	done   chan struct{}
+	client *ssh.Client
+	err    error
+}
+
+// NewJumpHosts returns an initialized JumpHosts
+func NewJumpHosts(hosts []Host, sshConfig func(Host) *ssh.ClientConfig) *JumpHosts {
+	return &JumpHosts{
+		SSHConfig: sshConfig,
+		Hosts:     hosts,
+		clients:   make(map[string]*ssh.Client),
+		dialing:   make(map[string]*jumpDial),
+	}
+}
+
+// Dial connects to addr through the chain of jump hosts, returning a client
+// for addr, giving up if the context is done first
+func (j *JumpHosts) Dial(ctx context.Context, chain []string, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	bastion, err := j.client(ctx, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := bastion.DialContext(ctx, "tcp", addr)
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("connection to %s via jump host %s: %s", addr, chain[len(chain)-1], ctx.Err())
+	} else if err != nil && alive(bastion) {
+		// The bastion is fine, it's addr that isn't, and other hosts may be using the bastion
+		return nil, fmt.Errorf("connection to %s via jump host %s failed: %s", addr, chain[len(chain)-1], err)
+	} else if err != nil {
+		// The bastion has gone away under us, so try once more with a fresh one
+		logf(j.Debug, "Dialing %s via %s failed, reconnecting: %s\n", addr, chain, err)
+		j.drop(chain)
+		if bastion, err = j.client(ctx, chain); err != nil {
+			return nil, err
+		}
+		if conn, err = bastion.DialContext(ctx, "tcp", addr); err != nil {
+			return nil, fmt.Errorf("connection to %s via jump host %s failed: %s", addr, chain[len(chain)-1], err)
+		}
+	}
+
+	return handshake(ctx, conn, addr, config)
+}
+
+// Close closes all of the bastion connections
+func (j *JumpHosts) Close() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	for k, c := range j.clients {
+		c.Close()
+		delete(j.clients, k)
+	}
+}
+
+// client returns a connected client to the last jump host in the chain, dialing
+// (through the previous jumps) as needed. Dialing is done without the lock, so one
+// slow bastion doesn't hold up every other chain, and everyone else needing the same
+// jump host waits for that one dial, or for the context to be done.
+func (j *JumpHosts) client(ctx context.Context, chain []string) (*ssh.Client, error) {
+	var prev *ssh.Client
+	for i := range chain {
+		key := strings.Join(chain[:i+1], ",")
+
+		j.lock.Lock()
+		if c, ok := j.clients[key]; ok {
+			j.lock.Unlock()
+			prev = c
+			continue
+		}
+		d, ok := j.dialing[key]
+		if !ok {
+			d = &jumpDial{done: make(chan struct{})}
+			j.dialing[key] = d
+		}
+		j.lock.Unlock()
+
+		if ok {
+			// Someone else is already dialing it
+			select {
+			case <-d.done:
+			case <-ctx.Done():
+				return nil, fmt.Errorf("connection to jump host %s: %s", chain[i], ctx.Err())
+			}
+		} else {
+			h := j.resolve(chain[i])
+			addr := h.ConnectAddress()
+			logf(j.Debug, "Connecting to jump host %s (%s)\n", chain[i], addr)
+
+			d.client, d.err = j.dial(ctx, prev, addr, j.SSHConfig(h))
+			if d.err != nil {
+				d.err = fmt.Errorf("connection to jump host %s failed: %s", chain[i], d.err)
+			}
+
+			j.lock.Lock()
+			delete(j.dialing, key)
+			if d.err == nil {
+				j.clients[key] = d.client
+			}
+			j.lock.Unlock()
+			close(d.done)
+		}
+
+		if d.err != nil {
+			return nil, d.err
+		}
+		prev = d.client
+	}
+
+	return prev, nil
+}
+
+// dial connects to addr, through prev if it isn't nil, giving up if the context is
+// done first
+func (j *JumpHosts) dial(ctx context.Context, prev *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if prev == nil {
+		return dialSSH(ctx, addr, config)
+	}
+
+	conn, err := prev.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return handshake(ctx, conn, addr, config)
+}
+
+// drop closes and forgets the connections for the jump hosts in the chain that are dead,
+// leaving the live ones to whoever else is using them
+func (j *JumpHosts) drop(chain []string) {
+	j.lock.Lock()
+	clients := make(map[string]*ssh.Client)
+	for i := range chain {
+		key := strings.Join(chain[:i+1], ",")
+		if c, ok := j.clients[key]; ok {
+			clients[key] = c
+		}
+	}
+	j.lock.Unlock()
+
+	for key, c := range clients {
+		if alive(c) {
+			continue
+		}
+		c.Close()
+
+		j.lock.Lock()
+		if j.clients[key] == c {
+			delete(j.clients, key)
+		}
+		j.lock.Unlock()
+	}
+}
+
+// resolve turns a "[user@]host[:port]" jump into a Host, using the configured
+// Host of the same Name if there is one
+func (j *JumpHosts) resolve(jump string) Host {
+	var h Host
+
+	if i := strings.LastIndex(jump, "@"); i >= 0 {
+		h.User = jump[:i]
+		jump = jump[i+1:]
+	}
+
+	if host, port, err := net.SplitHostPort(jump); err == nil {
+		h.Name = host
+		h.Port, _ = strconv.Atoi(port)
+	} else {
+		h.Name = jump
+	}
+
+	for _, ch := range j.Hosts {
+		if ch.Name == h.Name {
+			// Explicit jump bits trump the configured ones
+			if h.User != "" {
+				ch.User = h.User
+			}
+			if h.Port != 0 {
+				ch.Port = h.Port
+			}
+			return ch
+		}
+	}
+
+	return h
+}
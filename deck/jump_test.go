@@ -0,0 +1,24 @@
+package deck
+
+import (
+	"testing"
+)
+
+func TestJumpHosts_Resolve(t *testing.T) {
+	j := NewJumpHosts([]Host{{Name: "bastion", Address: "10.0.0.1", User: "jumper"}}, nil)
+
+	h := j.resolve("bastion")
+	if h.ConnectAddress() != "10.0.0.1:22" || h.User != "jumper" {
+		t.Errorf("Expected configured bastion, got %+v\n", h)
+	}
+
+	h = j.resolve("admin@bastion:2222")
+	if h.ConnectAddress() != "10.0.0.1:2222" || h.User != "admin" {
+		t.Errorf("Expected overridden bastion, got %+v\n", h)
+	}
+
+	h = j.resolve("admin@other.example.com:2022")
+	if h.ConnectAddress() != "other.example.com:2022" || h.User != "admin" {
+		t.Errorf("Expected unconfigured jump, got %+v\n", h)
+	}
+}
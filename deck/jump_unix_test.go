@@ -0,0 +1,94 @@
+//go:build !windows && !plan9
+
+package deck
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestJumpHosts_Dial(t *testing.T) {
+	bastion := newTestSSHServer(t)
+	bastion.Host.Name = "bastion"
+	targets := []*testSSHServer{newTestSSHServer(t), newTestSSHServer(t)}
+
+	jumps := NewJumpHosts([]Host{bastion.Host}, func(Host) *ssh.ClientConfig {
+		return bastion.Command("").SSHConfig
+	})
+	defer jumps.Close()
+
+	var wg sync.WaitGroup
+	for _, s := range targets {
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func(s *testSSHServer) {
+				defer wg.Done()
+				com := s.Command("echo hello")
+				com.Host.Jump = "bastion"
+				com.Jumps = jumps
+				cr := com.Exec()
+				if cr.Error != nil {
+					t.Errorf("Unexpected error: %s\n", cr.Error)
+				} else if out := cr.StdoutString(false); out != "hello\n" {
+					t.Errorf("Expected 'hello', got '%s'\n", out)
+				}
+			}(s)
+		}
+	}
+	wg.Wait()
+
+	if c := atomic.LoadInt32(&bastion.Conns); c != 1 {
+		t.Errorf("Expected 1 connection to the bastion, shared, got %d\n", c)
+	}
+	for _, s := range targets {
+		if c := atomic.LoadInt32(&s.Conns); c != 3 {
+			t.Errorf("Expected 3 connections to the target, via the bastion, got %d\n", c)
+		}
+	}
+}
+
+func TestJumpHosts_DialUnreachable(t *testing.T) {
+	bastion := newTestSSHServer(t)
+	bastion.Host.Name = "bastion"
+	target := newTestSSHServer(t)
+
+	jumps := NewJumpHosts([]Host{bastion.Host}, func(Host) *ssh.ClientConfig {
+		return bastion.Command("").SSHConfig
+	})
+	defer jumps.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		com := target.Command("sleep 0.5; echo done")
+		com.Host.Jump = "bastion"
+		com.Jumps = jumps
+		if cr := com.Exec(); cr.Error != nil {
+			t.Errorf("Expected the reachable host to be unaffected, got: %s\n", cr.Error)
+		} else if out := cr.StdoutString(false); out != "done\n" {
+			t.Errorf("Expected 'done', got '%s'\n", out)
+		}
+	}()
+
+	// Let it get going
+	time.Sleep(100 * time.Millisecond)
+
+	// Nothing's listening behind the bastion
+	com := target.Command("true")
+	com.Host.Port = 1
+	com.Host.Jump = "bastion"
+	com.Jumps = jumps
+	if cr := com.Exec(); cr.Error == nil || cr.ErrorType != ErrorConnection {
+		t.Errorf("Expected a connection error for the unreachable host, got %s: %v\n", cr.ErrorType, cr.Error)
+	}
+	wg.Wait()
+
+	if c := atomic.LoadInt32(&bastion.Conns); c != 1 {
+		t.Errorf("Expected 1 connection to the bastion, kept, got %d\n", c)
+	}
+}
@@ -0,0 +1,142 @@
+package deck
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// JUnitReport is a JUnit XML report of a run, for CI: Each host is a test suite, and
+// each command it ran (each workflow step) is a test case, which failed if it exited
+// non-zero or errored.
+type JUnitReport struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr,omitempty"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []JUnitSuite `xml:"testsuite"`
+
+	lock sync.Mutex
+}
+
+// JUnitSuite is a host in a JUnitReport
+type JUnitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr,omitempty"`
+	Cases     []JUnitCase `xml:"testcase"`
+
+	seconds float64
+}
+
+// JUnitCase is a command in a JUnitSuite
+type JUnitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *JUnitFailure `xml:"failure,omitempty"`
+	Skipped   *JUnitFailure `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+// JUnitFailure is why a JUnitCase failed, or was skipped
+type JUnitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+}
+
+// NewJUnitReport returns an empty JUnitReport, named name (e.g. the workflow)
+func NewJUnitReport(name string) *JUnitReport {
+	return &JUnitReport{Name: name}
+}
+
+// Add adds the Result to the JUnitReport, as a suite. Hosts with no commands, because
+// they were skipped or timed out, have a single case, named for the report, saying so.
+func (j *JUnitReport) Add(res Result) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	suite := JUnitSuite{Name: res.Host.ID()}
+
+	crs := res.CommandReturns
+	if res.Workflow != nil {
+		crs = res.Workflow.CommandReturns
+	}
+
+	for i := range crs {
+		cr := &crs[i]
+		tc := JUnitCase{
+			Name:      cr.Command,
+			Classname: res.Host.ID(),
+			Time:      fmt.Sprintf("%.3f", cr.Duration().Seconds()),
+		}
+		if cr.Workflow != "" {
+			tc.Name = fmt.Sprintf("%s step %d: %s", cr.Workflow, cr.Step+1, cr.Command)
+		}
+
+		if cr.Error != nil {
+			tc.Failure = &JUnitFailure{Message: cr.Error.Error(), Type: cr.ErrorType}
+			suite.Failures++
+		}
+		if !cr.Quiet {
+			tc.SystemOut = cr.StdoutString(true)
+			tc.SystemErr = cr.StderrString(true)
+		}
+
+		if suite.Timestamp == "" && !cr.Start.IsZero() {
+			suite.Timestamp = cr.Start.UTC().Format("2006-01-02T15:04:05")
+		}
+		suite.seconds += cr.Duration().Seconds()
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	if len(crs) == 0 {
+		tc := JUnitCase{Name: j.Name, Classname: res.Host.ID(), Time: "0.000"}
+		if tc.Name == "" {
+			tc.Name = res.Status
+		}
+		if res.Status == StatusSkipped {
+			tc.Skipped = &JUnitFailure{Message: "host was skipped"}
+			suite.Skipped++
+		} else {
+			tc.Failure = &JUnitFailure{Message: "host " + res.Status, Type: res.Status}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	suite.Tests = len(suite.Cases)
+	suite.Time = fmt.Sprintf("%.3f", suite.seconds)
+	j.Suites = append(j.Suites, suite)
+}
+
+// ToXML returns the JUnitReport as XML, with the suites in order by host name
+func (j *JUnitReport) ToXML() []byte {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	sort.SliceStable(j.Suites, func(a, b int) bool {
+		return j.Suites[a].Name < j.Suites[b].Name
+	})
+
+	j.Tests, j.Failures, j.Skipped = 0, 0, 0
+	var seconds float64
+	for _, s := range j.Suites {
+		j.Tests += s.Tests
+		j.Failures += s.Failures
+		j.Skipped += s.Skipped
+		seconds += s.seconds
+	}
+	j.Time = fmt.Sprintf("%.3f", seconds)
+
+	// Only strings and numbers, so it always marshals
+	x, _ := xml.MarshalIndent(j, "", "  ")
+	return append([]byte(xml.Header), append(x, '\n')...)
+}
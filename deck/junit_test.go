@@ -0,0 +1,70 @@
+package deck
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJUnitReport(t *testing.T) {
+	j := NewJUnitReport("smoke")
+
+	ok := CommandReturn{Workflow: "smoke", Step: 0, Command: "uptime", Start: time.Now()}
+	ok.End = ok.Start.Add(250 * time.Millisecond)
+	ok.Stdout.WriteString("up\n")
+	bad := CommandReturn{Workflow: "smoke", Step: 1, Command: "false", Error: fmt.Errorf("exit 1"), ErrorType: ErrorExit, ExitCode: 1}
+	bad.Stderr.WriteString("oops\n")
+
+	wr := &WorkflowReturn{Name: "smoke", CommandReturns: []CommandReturn{ok, bad}}
+	j.Add(Result{Host: Host{Name: "web02"}, Status: StatusFailed, Step: 2, Workflow: wr, CommandReturns: wr.CommandReturns})
+	j.Add(Result{Host: Host{Name: "web01"}, Status: StatusSkipped})
+
+	x := j.ToXML()
+	if !strings.HasPrefix(string(x), xml.Header) {
+		t.Errorf("Expected XML header, got '%s'\n", x)
+	}
+
+	var back JUnitReport
+	if err := xml.Unmarshal(x, &back); err != nil {
+		t.Fatalf("Unexpected error parsing report: %s\n", err)
+	}
+	if back.Tests != 3 || back.Failures != 1 || back.Skipped != 1 || len(back.Suites) != 2 {
+		t.Fatalf("Unexpected totals: %d tests, %d failures, %d skipped, %d suites\n", back.Tests, back.Failures, back.Skipped, len(back.Suites))
+	}
+
+	if s := back.Suites[0]; s.Name != "web01" || s.Skipped != 1 || s.Cases[0].Skipped == nil || s.Cases[0].Name != "smoke" {
+		t.Errorf("Expected skipped web01 first, got %+v\n", s)
+	}
+
+	s := back.Suites[1]
+	if s.Name != "web02" || s.Tests != 2 || s.Failures != 1 || s.Time != "0.250" {
+		t.Fatalf("Unexpected web02 suite: %+v\n", s)
+	}
+	if c := s.Cases[0]; c.Name != "smoke step 1: uptime" || c.Failure != nil || c.SystemOut != "up\n" {
+		t.Errorf("Unexpected passing case: %+v\n", c)
+	}
+	if c := s.Cases[1]; c.Failure == nil || c.Failure.Type != ErrorExit || c.SystemErr != "oops\n" {
+		t.Errorf("Unexpected failing case: %+v\n", c)
+	}
+}
+
+func TestJUnitReport_SameName(t *testing.T) {
+	j := NewJUnitReport("smoke")
+	for _, addr := range []string{"10.0.0.2", "10.0.0.1"} {
+		h := Host{Name: "web", Address: addr}
+		j.Add(Result{Host: h, Status: StatusSucceeded, CommandReturns: []CommandReturn{{HostObj: h, Command: "uptime"}}})
+	}
+
+	var back JUnitReport
+	if err := xml.Unmarshal(j.ToXML(), &back); err != nil {
+		t.Fatalf("Unexpected error parsing report: %s\n", err)
+	}
+	if len(back.Suites) != 2 || back.Suites[0].Name != "web/10.0.0.1" || back.Suites[1].Name != "web/10.0.0.2" {
+		t.Fatalf("Expected a suite for each host named web, got %+v\n", back.Suites)
+	}
+	if c := back.Suites[0].Cases[0]; c.Classname != "web/10.0.0.1" {
+		t.Errorf("Expected the case to be classed by the host, got %+v\n", c)
+	}
+}
@@ -1,6 +1,6 @@
 //go:build go1.4 && !plan9
 
-package main
+package deck
 
 import (
 	"crypto/rand"
@@ -8,9 +8,6 @@ import (
 	"strings"
 )
 
-// GlobalVars is a horrific global string map
-var GlobalVars map[string]string
-
 // Misc is a simple key/value structure
 type Misc struct {
 	Name  string
@@ -127,6 +124,11 @@ func makeList(list []string) []string {
 	return newList
 }
 
+// shellQuote single-quotes a string, so a remote shell takes it literally
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
 // Return a randomish string of the specified size
 func randString(size int) string {
 	chars := "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
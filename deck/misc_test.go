@@ -1,4 +1,4 @@
-package main
+package deck
 
 import (
 	"strings"
@@ -217,3 +217,9 @@ func stringArrayEquality(X, Y []string) bool {
 	}
 	return true
 }
+
+func TestShellQuote(t *testing.T) {
+	if q := shellQuote("/tmp/it's here"); q != `'/tmp/it'"'"'s here'` {
+		t.Errorf("Unexpected quoting: %s\n", q)
+	}
+}
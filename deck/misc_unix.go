@@ -0,0 +1,46 @@
+//go:build go1.4 && !windows && !plan9
+
+// UNIX-specific stuff goes here, so we do not break the Windows.
+package deck
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+func getOpenFiles() ([]string, error) {
+	out, err := exec.Command("/bin/bash", "-c", fmt.Sprintf("lsof -np %v", os.Getpid())).Output()
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(out), "\n")
+	return lines, nil
+}
+
+// saneMaxLimit returns how many hosts may be connected to at once, given the open files limit.
+// Every host gets a single connection, no matter how many commands are executed on it.
+func (r *Runner) saneMaxLimit() int {
+	var rLimit syscall.Rlimit
+	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rLimit)
+	if err != nil {
+		r.errorf("Error getting Rlimit, falling back to GOMAXPROCS: %v\n", err)
+		return runtime.GOMAXPROCS(0)
+	}
+
+	lines, err := getOpenFiles()
+	if err != nil {
+		r.errorf("Error listing open files, falling back to GOMAXPROCS: %v\n", err)
+		return runtime.GOMAXPROCS(0)
+	}
+	of := len(lines) - 1
+	oflimit := int(rLimit.Cur)
+	avail := oflimit - of
+
+	r.debugf("Open files: %d of %d (%d avail)\n", of, oflimit, avail)
+
+	return avail / 2
+}
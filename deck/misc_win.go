@@ -1,12 +1,13 @@
 //go:build go1.4 && windows
 
 // Windows-specific stuff goes here, so we can accommodate the broken Windows.
-package main
+package deck
 
 import (
 	"runtime"
 )
 
-func saneMaxLimit(sessionCount int) int {
+// saneMaxLimit returns how many hosts may be connected to at once
+func (r *Runner) saneMaxLimit() int {
 	return runtime.GOMAXPROCS(0)
 }
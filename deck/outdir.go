@@ -0,0 +1,201 @@
+package deck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OutDirMeta is the meta.json written for each host by WriteOutDir
+type OutDirMeta struct {
+	Host     string
+	Address  string
+	Status   string
+	Workflow string `json:",omitempty"`
+	// Step is the workflow command the host reached, starting at 1. 0 is none, or not a workflow.
+	Step     int `json:",omitempty"`
+	Commands []OutDirCommand
+}
+
+// OutDirCommand describes a command in an OutDirMeta, and the files its output is in
+type OutDirCommand struct {
+	// File is the name of the .stdout and .stderr files, without the extension
+	File     string
+	Workflow string `json:",omitempty"`
+	// Step is the workflow command this is from, starting at 1. 0 is not a workflow.
+	Step       int `json:",omitempty"`
+	Command    string
+	Status     string
+	Error      string `json:",omitempty"`
+	ErrorType  string `json:",omitempty"`
+	ExitCode   int
+	ExitSignal string `json:",omitempty"`
+	Start      time.Time
+	End        time.Time
+	Duration   string
+	// Connect, Session, and Exec are how long connecting, getting a session, and
+	// executing took, as CommandReturn's
+	Connect string
+	Session string
+	Exec    string
+}
+
+// WriteOutDir writes the Result into dir/<host name>_<address>/: The stdout and stderr of each of
+// its commands, as <step>.stdout and <step>.stderr (see outFiles), and a meta.json describing
+// them. Those from an earlier run are removed first, so they don't mix with these.
+func WriteOutDir(dir string, res Result) error {
+	hname, err := hostDirName(res.Host)
+	if err != nil {
+		return err
+	}
+	hdir := filepath.Join(dir, hname)
+	if err = os.MkdirAll(hdir, 0755); err != nil {
+		return err
+	}
+	if err = clearOutDir(hdir); err != nil {
+		return err
+	}
+
+	meta := OutDirMeta{
+		Host:     res.Host.Name,
+		Address:  res.Host.Address,
+		Status:   res.Status,
+		Step:     res.Step,
+		Commands: []OutDirCommand{},
+	}
+	if res.Workflow != nil {
+		meta.Workflow = res.Workflow.Name
+	}
+
+	files := outFiles(meta.Workflow, res.CommandReturns)
+	for i := range res.CommandReturns {
+		cr := &res.CommandReturns[i]
+		oc := OutDirCommand{
+			File:       files[i],
+			Workflow:   cr.Workflow,
+			Command:    cr.Command,
+			Status:     cr.Status(),
+			ErrorType:  cr.ErrorType,
+			ExitCode:   cr.ExitCode,
+			ExitSignal: cr.ExitSignal,
+			Start:      cr.Start,
+			End:        cr.End,
+			Duration:   cr.Duration().String(),
+			Connect:    cr.Connect.String(),
+			Session:    cr.Session.String(),
+			Exec:       cr.Exec.String(),
+		}
+		if cr.Workflow != "" {
+			oc.Step = cr.Step + 1
+		}
+		if cr.Error != nil {
+			oc.Error = cr.Error.Error()
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(hdir, oc.File+".stdout"), cr.Stdout.Bytes(), 0644); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(hdir, oc.File+".stderr"), cr.Stderr.Bytes(), 0644); err != nil {
+			return err
+		}
+		meta.Commands = append(meta.Commands, oc)
+	}
+
+	j, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(hdir, "meta.json"), append(j, '\n'), 0644)
+}
+
+// outFiles returns the names of the files for each of the CommandReturns, without the
+// extension: The workflow step it's from, starting at 1, prefixed by the name of its
+// workflow and an underscore if that was chained to, rather than being workflow. Commands that
+// aren't from a workflow are step 1. Steps that ran more than one command (FORs,
+// workflows chained more than once) have each numbered after a dash, starting at 1.
+// Steps are zero-padded so they sort.
+func outFiles(workflow string, crs []CommandReturn) []string {
+	var maxStep int
+	for i := range crs {
+		if crs[i].Step > maxStep {
+			maxStep = crs[i].Step
+		}
+	}
+	width := len(strconv.Itoa(maxStep + 1))
+
+	files := make([]string, len(crs))
+	counts := make(map[string]int)
+	for i := range crs {
+		cr := &crs[i]
+		files[i] = fmt.Sprintf("%0*d", width, cr.Step+1)
+		if cr.Workflow != "" && cr.Workflow != workflow {
+			files[i] = safeFileName(cr.Workflow) + "_" + files[i]
+		}
+		counts[files[i]]++
+	}
+
+	seen := make(map[string]int)
+	for i, f := range files {
+		if counts[f] > 1 {
+			seen[f]++
+			files[i] = fmt.Sprintf("%s-%d", f, seen[f])
+		}
+	}
+	return files
+}
+
+// clearOutDir removes the .stdout, .stderr, and meta.json files WriteOutDir writes from
+// the folder, leaving anything else
+func clearOutDir(hdir string) error {
+	entries, err := os.ReadDir(hdir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || (name != "meta.json" && filepath.Ext(name) != ".stdout" && filepath.Ext(name) != ".stderr") {
+			continue
+		}
+		if err = os.Remove(filepath.Join(hdir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// safeFileName returns the name with path separators replaced
+func safeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == filepath.Separator {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// hostDirName returns a folder name unique to the Host: <name>_<address>, or just the
+// one of them it has, with the port if it isn't 22. Path separators are replaced, and
+// names that would leave the folder they're in are refused.
+func hostDirName(h Host) (string, error) {
+	var parts []string
+	for _, p := range []string{h.Name, h.Address} {
+		if p != "" && (len(parts) == 0 || parts[0] != p) {
+			parts = append(parts, p)
+		}
+	}
+	if h.Port != 0 && h.Port != 22 {
+		parts = append(parts, strconv.Itoa(h.Port))
+	}
+
+	name := safeFileName(strings.Join(parts, "_"))
+
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("host '%s' cannot be used as a folder name", name)
+	}
+	return name, nil
+}
@@ -0,0 +1,132 @@
+package deck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteOutDir(t *testing.T) {
+	dir := t.TempDir()
+	host := Host{Name: "web/01", Address: "10.0.0.1"}
+
+	res := Result{Host: host, Status: StatusFailed, Step: 2, Workflow: &WorkflowReturn{Name: "wf"}}
+	for i, code := range []int{0, 3} {
+		cr := CommandReturn{HostObj: host, Workflow: "wf", Step: i, Command: fmt.Sprintf("step%d", i)}
+		cr.Start = time.Now()
+		cr.End = cr.Start.Add(time.Second)
+		fmt.Fprintf(&cr.Stdout, "out%d\n", i)
+		if code != 0 {
+			cr.Error = fmt.Errorf("exit %d", code)
+			cr.ErrorType = ErrorExit
+			cr.ExitCode = code
+			cr.Stderr.WriteString("oops\n")
+		}
+		res.CommandReturns = append(res.CommandReturns, cr)
+	}
+
+	if err := WriteOutDir(dir, res); err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+
+	hdir := filepath.Join(dir, "web_01_10.0.0.1")
+	if b, err := ioutil.ReadFile(filepath.Join(hdir, "1.stdout")); err != nil || string(b) != "out0\n" {
+		t.Errorf("Expected 'out0' in 1.stdout, got '%s' (%v)\n", b, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(hdir, "2.stderr")); err != nil || string(b) != "oops\n" {
+		t.Errorf("Expected 'oops' in 2.stderr, got '%s' (%v)\n", b, err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(hdir, "meta.json"))
+	if err != nil {
+		t.Fatalf("Unexpected error reading meta.json: %s\n", err)
+	}
+	var meta OutDirMeta
+	if err = json.Unmarshal(b, &meta); err != nil {
+		t.Fatalf("Unexpected error parsing meta.json: %s\n", err)
+	}
+	if meta.Host != "web/01" || meta.Status != StatusFailed || meta.Workflow != "wf" || len(meta.Commands) != 2 {
+		t.Fatalf("Unexpected meta: %+v\n", meta)
+	}
+	if c := meta.Commands[1]; c.File != "2" || c.Step != 2 || c.ExitCode != 3 || c.Status != StatusFailed || c.Duration != "1s" {
+		t.Errorf("Unexpected meta for step 2: %+v\n", c)
+	}
+}
+
+func TestHostDirName(t *testing.T) {
+	for _, c := range []struct {
+		host Host
+		name string
+	}{
+		{Host{Name: "web01", Address: "10.0.0.1"}, "web01_10.0.0.1"},
+		{Host{Name: "web01", Address: "10.0.0.2"}, "web01_10.0.0.2"},
+		{Host{Name: "web01.example.com"}, "web01.example.com"},
+		{Host{Address: "10.0.0.1", Port: 2222}, "10.0.0.1_2222"},
+		{Host{Name: "10.0.0.1", Address: "10.0.0.1", Port: 22}, "10.0.0.1"},
+		{Host{Name: "../etc", Address: "fe80::1"}, ".._etc_fe80__1"},
+		{Host{Name: "..", Address: "10.0.0.1"}, ".._10.0.0.1"},
+	} {
+		if name, err := hostDirName(c.host); err != nil || name != c.name {
+			t.Errorf("Expected '%s' for %+v, got '%s' (%v)\n", c.name, c.host, name, err)
+		}
+	}
+
+	for _, h := range []Host{{}, {Name: "."}, {Name: ".."}, {Address: ".."}} {
+		if name, err := hostDirName(h); err == nil {
+			t.Errorf("Expected error for %+v, got '%s'\n", h, name)
+		}
+	}
+}
+
+func TestWriteOutDir_Steps(t *testing.T) {
+	dir := t.TempDir()
+	host := Host{Name: "web01"}
+	hdir := filepath.Join(dir, "web01")
+
+	// An earlier run's leftovers
+	if err := os.MkdirAll(hdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"07.stdout", "07.stderr", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(hdir, f), []byte("old\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res := Result{Host: host, Status: StatusSucceeded, Workflow: &WorkflowReturn{Name: "wf"}}
+	for _, cr := range []CommandReturn{
+		{Workflow: "wf", Step: 0},
+		{Workflow: "wf", Step: 2},
+		{Workflow: "wf", Step: 2},
+		{Workflow: "sub/flow", Step: 0},
+		{Workflow: "wf", Step: 10},
+	} {
+		cr.HostObj = host
+		res.CommandReturns = append(res.CommandReturns, cr)
+	}
+
+	if err := WriteOutDir(dir, res); err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+
+	entries, err := os.ReadDir(hdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	expected := []string{
+		"01.stderr", "01.stdout", "03-1.stderr", "03-1.stdout", "03-2.stderr", "03-2.stdout",
+		"11.stderr", "11.stdout", "meta.json", "notes.txt", "sub_flow_01.stderr", "sub_flow_01.stdout",
+	}
+	if strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected files %v, got %v\n", expected, names)
+	}
+}
@@ -0,0 +1,257 @@
+package deck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultMaxSessions is how many sessions may be open on a single connection at
+// once. OpenSSH's MaxSessions defaults to 10, and we like a little headroom.
+const defaultMaxSessions = 8
+
+// sessionRetries is how many times we ask a live connection for a session, before giving
+// up, backing off from sessionBackoff, doubling each time
+const (
+	sessionRetries = 8
+	sessionBackoff = 50 * time.Millisecond
+)
+
+// Connections caches one SSH connection per host, so that every command executed
+// against a host in a run gets its own session on a single, shared, connection
+type Connections struct {
+	MaxSessions int
+
+	conns map[string]*hostConn
+	lock  sync.Mutex
+}
+
+// hostConn is a connection to a single host
+type hostConn struct {
+	client   *ssh.Client
+	sessions chan bool
+	// holders is how many Hold it, without having Released it, under the Connections lock
+	holders int
+	lock    sync.Mutex
+}
+
+// NewConnections returns an initialized Connections
+func NewConnections() *Connections {
+	return &Connections{
+		MaxSessions: defaultMaxSessions,
+		conns:       make(map[string]*hostConn),
+	}
+}
+
+// Session returns a new session to the Command's Host, connecting or reconnecting
+// as needed. The returned function must be called when the session is no longer needed.
+func (p *Connections) Session(ctx context.Context, c *Command) (*ssh.Session, func(), error) {
+	return p.session(ctx, c, nil)
+}
+
+// session is Session, adding how long connecting took, if it did, to connect, if not nil
+func (p *Connections) session(ctx context.Context, c *Command, connect *time.Duration) (*ssh.Session, func(), error) {
+	hc := p.get(c)
+
+	// Wait our turn
+	select {
+	case hc.sessions <- true:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+
+	session, err := hc.session(ctx, c, connect)
+	if err != nil {
+		<-hc.sessions
+		return nil, nil, err
+	}
+
+	return session, func() {
+		session.Close()
+		<-hc.sessions
+	}, nil
+}
+
+// Hold keeps the connection for the Command open, for everything else that uses it, until
+// it's Released. Hosts sharing the connection (see connKey) each hold it for as long as
+// it's running.
+func (p *Connections) Hold(c *Command) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.getLocked(c).holders++
+}
+
+// Release lets go of the connection for the Command, closing it if nothing else holds
+// it. Later sessions will reconnect.
+func (p *Connections) Release(c *Command) {
+	key := connKey(c)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	hc, ok := p.conns[key]
+	if !ok {
+		return
+	}
+	if hc.holders--; hc.holders > 0 {
+		return
+	}
+	hc.close()
+	delete(p.conns, key)
+}
+
+// Close closes all of the connections
+func (p *Connections) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for k, hc := range p.conns {
+		hc.close()
+		delete(p.conns, k)
+	}
+}
+
+// connKey is what the connection for the Command is shared by: its user and address, the
+// jump hosts it's reached through, and its pinned HostKey, so that a connection is only
+// shared by hosts that would have been verified, and reached, the same way
+func connKey(c *Command) string {
+	key := c.SSHConfig.User + "@" + c.Host.ConnectAddress()
+	if chain := c.Host.JumpChain(c.Runner.vars()["jumphost"]); len(chain) > 0 {
+		key += " via " + strings.Join(chain, ",")
+	}
+	if c.Host.HostKey != "" {
+		key += " pinned " + strings.TrimSpace(c.Host.HostKey)
+	}
+	return key
+}
+
+// get returns the hostConn for the Command, creating it if needed
+func (p *Connections) get(c *Command) *hostConn {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.getLocked(c)
+}
+
+// getLocked is get, with the lock already held
+func (p *Connections) getLocked(c *Command) *hostConn {
+	key := connKey(c)
+
+	hc, ok := p.conns[key]
+	if !ok {
+		max := p.MaxSessions
+		if max < 1 {
+			max = defaultMaxSessions
+		}
+		hc = &hostConn{
+			sessions: make(chan bool, max),
+		}
+		p.conns[key] = hc
+	}
+	return hc
+}
+
+// session opens a new session, connecting first if there isn't a live connection. If
+// the connection is dead (e.g. sshd was restarted), we reconnect once and retry. If the
+// host merely refused the session (e.g. its MaxSessions is lower than ours), the
+// connection is left alone for the sessions already on it, and we back off and retry.
+func (hc *hostConn) session(ctx context.Context, c *Command, connect *time.Duration) (*ssh.Session, error) {
+	var (
+		reconnected bool
+		backoff     = sessionBackoff
+	)
+	for tries := 1; ; tries++ {
+		client, err := hc.connect(ctx, c, connect)
+		if err != nil {
+			return nil, err
+		}
+
+		session, err := client.NewSession()
+		if err == nil {
+			return session, nil
+		}
+
+		if !alive(client) {
+			// The connection is dead, so everything on it is already gone
+			hc.lock.Lock()
+			if hc.client == client {
+				hc.client.Close()
+				hc.client = nil
+			}
+			hc.lock.Unlock()
+
+			if reconnected {
+				return nil, fmt.Errorf("session to %s failed: %s", c.Host.ConnectAddress(), err)
+			}
+			reconnected = true
+			c.Runner.debugf("Session to %s failed, reconnecting: %s\n", c.Host.ConnectAddress(), err)
+			continue
+		}
+
+		if tries >= sessionRetries {
+			return nil, fmt.Errorf("session to %s failed: %s", c.Host.ConnectAddress(), err)
+		}
+		c.Runner.debugf("Session to %s refused, retrying in %s: %s\n", c.Host.ConnectAddress(), backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// connect returns the live client, dialing if there isn't one, and adding how long
+// that took to connect, if not nil. Dialing gives up if the context is done first.
+func (hc *hostConn) connect(ctx context.Context, c *Command, connect *time.Duration) (*ssh.Client, error) {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	if hc.client != nil {
+		return hc.client, nil
+	}
+
+	start := time.Now()
+	client, err := c.dial(ctx)
+	if connect != nil {
+		*connect += time.Since(start)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hc.client = client
+
+	// Forget the client if the connection goes away
+	go func() {
+		client.Wait()
+		hc.lock.Lock()
+		if hc.client == client {
+			hc.client = nil
+		}
+		hc.lock.Unlock()
+	}()
+
+	return client, nil
+}
+
+// alive returns true if the client's connection is still up, as a keepalive gets an
+// answer, even a refusal
+func alive(c *ssh.Client) bool {
+	_, _, err := c.SendRequest("keepalive@openssh.com", true, nil)
+	return err == nil
+}
+
+func (hc *hostConn) close() {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	if hc.client != nil {
+		hc.client.Close()
+		hc.client = nil
+	}
+}
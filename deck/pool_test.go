@@ -0,0 +1,270 @@
+//go:build !windows && !plan9
+
+package deck
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestConnections_Reuse(t *testing.T) {
+	s := newTestSSHServer(t)
+	conns := NewConnections()
+	defer conns.Close()
+
+	for i := 0; i < 5; i++ {
+		com := s.Command("echo " + strconv.Itoa(i))
+		com.Conns = conns
+		cr := com.Exec()
+		if cr.Error != nil {
+			t.Fatalf("Unexpected error: %s\n", cr.Error)
+		}
+		if out := cr.StdoutString(false); out != strconv.Itoa(i)+"\n" {
+			t.Errorf("Expected '%d', got '%s'\n", i, out)
+		}
+		if i == 0 && cr.Connect <= 0 {
+			t.Error("Expected the first command to take time connecting")
+		} else if i > 0 && cr.Connect != 0 {
+			t.Errorf("Expected no time connecting on a reused connection, got %s\n", cr.Connect)
+		}
+	}
+
+	if c := atomic.LoadInt32(&s.Conns); c != 1 {
+		t.Errorf("Expected 1 connection, got %d\n", c)
+	}
+}
+
+func TestConnections_Reconnect(t *testing.T) {
+	s := newTestSSHServer(t)
+	conns := NewConnections()
+	defer conns.Close()
+
+	com := s.Command("true")
+	com.Conns = conns
+	if cr := com.Exec(); cr.Error != nil {
+		t.Fatalf("Unexpected error: %s\n", cr.Error)
+	}
+
+	// Pull the rug out
+	conns.Release(&com)
+
+	if cr := com.Exec(); cr.Error != nil {
+		t.Fatalf("Unexpected error after release: %s\n", cr.Error)
+	}
+
+	if c := atomic.LoadInt32(&s.Conns); c != 2 {
+		t.Errorf("Expected 2 connections, got %d\n", c)
+	}
+}
+
+func TestConnections_Concurrent(t *testing.T) {
+	s := newTestSSHServer(t)
+	conns := NewConnections()
+	conns.MaxSessions = 2
+	defer conns.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			com := s.Command("true")
+			com.Conns = conns
+			if cr := com.Exec(); cr.Error != nil {
+				t.Errorf("Unexpected error: %s\n", cr.Error)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c := atomic.LoadInt32(&s.Conns); c != 1 {
+		t.Errorf("Expected 1 connection, got %d\n", c)
+	}
+}
+
+func TestConnections_ServerDrop(t *testing.T) {
+	s := newTestSSHServer(t)
+	conns := NewConnections()
+	defer conns.Close()
+
+	com := s.Command("true")
+	com.Conns = conns
+	if cr := com.Exec(); cr.Error != nil {
+		t.Fatalf("Unexpected error: %s\n", cr.Error)
+	}
+
+	// Pull the rug out from the other side, as a restarted sshd would
+	s.Drop()
+
+	if cr := com.Exec(); cr.Error != nil {
+		t.Fatalf("Unexpected error after the server dropped us: %s\n", cr.Error)
+	}
+
+	if c := atomic.LoadInt32(&s.Conns); c != 2 {
+		t.Errorf("Expected 2 connections, got %d\n", c)
+	}
+}
+
+func TestConnections_SessionsRefused(t *testing.T) {
+	s := newTestSSHServer(t)
+	s.MaxSessions = 2
+	conns := NewConnections()
+	conns.MaxSessions = 6
+	defer conns.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			com := s.Command("sleep 0.1")
+			com.Conns = conns
+			if cr := com.Exec(); cr.Error != nil {
+				t.Errorf("Unexpected error: %s\n", cr.Error)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The refused sessions must not have taken the others down with them
+	if c := atomic.LoadInt32(&s.Conns); c != 1 {
+		t.Errorf("Expected 1 connection, got %d\n", c)
+	}
+}
+
+func TestConnections_HoldRelease(t *testing.T) {
+	s := newTestSSHServer(t)
+	conns := NewConnections()
+	defer conns.Close()
+
+	// Two hosts at the one address, as the same user
+	slow := s.Command("sleep 0.5; echo done")
+	slow.Host.Name = "slow"
+	slow.Conns = conns
+	fast := s.Command("true")
+	fast.Host.Name = "fast"
+	fast.Conns = conns
+
+	conns.Hold(&slow)
+	conns.Hold(&fast)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer conns.Release(&slow)
+		if cr := slow.Exec(); cr.Error != nil {
+			t.Errorf("Expected slow to be unaffected by fast, got: %s\n", cr.Error)
+		} else if out := cr.StdoutString(false); out != "done\n" {
+			t.Errorf("Expected 'done', got '%s'\n", out)
+		}
+	}()
+
+	if cr := fast.Exec(); cr.Error != nil {
+		t.Errorf("Unexpected error: %s\n", cr.Error)
+	}
+	conns.Release(&fast)
+	wg.Wait()
+
+	if c := atomic.LoadInt32(&s.Conns); c != 1 {
+		t.Errorf("Expected 1 connection, shared, got %d\n", c)
+	}
+}
+
+func TestConnections_HoldReleaseRace(t *testing.T) {
+	conns := NewConnections()
+	defer conns.Close()
+
+	s := newTestSSHServer(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			com := s.Command("true")
+			com.Host.Name = "host" + strconv.Itoa(i)
+			for j := 0; j < 100; j++ {
+				conns.Hold(&com)
+				conns.lock.Lock()
+				if hc, ok := conns.conns[connKey(&com)]; !ok || hc.holders < 1 {
+					t.Errorf("Expected the held connection to be held\n")
+				}
+				conns.lock.Unlock()
+				conns.Release(&com)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(conns.conns); n != 0 {
+		t.Errorf("Expected every connection released, got %d\n", n)
+	}
+}
+
+func TestConnections_PinnedNotShared(t *testing.T) {
+	s := newTestSSHServer(t)
+	conns := NewConnections()
+	defer conns.Close()
+
+	checker, err := NewHostKeyChecker(HostKeyOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two hosts at the one address, as the same user, one pinned to a key it doesn't have
+	open := s.Command("true")
+	open.Host.Name = "open"
+	open.Conns = conns
+	pinned := s.Command("true")
+	pinned.Host.Name = "pinned"
+	pinned.Host.HostKey = ssh.FingerprintSHA256(newTestHostKey(t))
+	pinned.SSHConfig.HostKeyCallback = checker.Callback(pinned.Host)
+	pinned.Conns = conns
+
+	conns.Hold(&open)
+	defer conns.Release(&open)
+	if cr := open.Exec(); cr.Error != nil {
+		t.Fatalf("Unexpected error: %s\n", cr.Error)
+	}
+
+	conns.Hold(&pinned)
+	defer conns.Release(&pinned)
+	if cr := pinned.Exec(); cr.ErrorType != ErrorConnection {
+		t.Errorf("Expected the pinned host's key to be checked, got %v (%s)\n", cr.Error, cr.ErrorType)
+	}
+}
+
+func TestConnections_JumpsNotShared(t *testing.T) {
+	target := newTestSSHServer(t)
+	bastions := []*testSSHServer{newTestSSHServer(t), newTestSSHServer(t)}
+	bastions[0].Host.Name = "bastion1"
+	bastions[1].Host.Name = "bastion2"
+
+	conns := NewConnections()
+	defer conns.Close()
+	jumps := NewJumpHosts([]Host{bastions[0].Host, bastions[1].Host}, func(Host) *ssh.ClientConfig {
+		return target.Command("").SSHConfig
+	})
+	defer jumps.Close()
+
+	// The same address, behind different bastions
+	for _, jump := range []string{"", "bastion1", "bastion2", "bastion1"} {
+		com := target.Command("true")
+		com.Host.Jump = jump
+		com.Conns = conns
+		com.Jumps = jumps
+		conns.Hold(&com)
+		defer conns.Release(&com)
+		if cr := com.Exec(); cr.Error != nil {
+			t.Fatalf("Unexpected error via '%s': %s\n", jump, cr.Error)
+		}
+	}
+
+	if c := atomic.LoadInt32(&target.Conns); c != 3 {
+		t.Errorf("Expected 3 connections, one per route, got %d\n", c)
+	}
+}
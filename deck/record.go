@@ -0,0 +1,109 @@
+package deck
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecordFields are the names of the fields of a Record, in order, as the header of
+// the csv format
+var RecordFields = []string{
+	"RunID", "Workflow", "Step", "Host", "Address", "Command", "Status", "ErrorType",
+	"Error", "ExitCode", "ExitSignal", "Start", "End", "Seconds", "Stdout", "Stderr",
+	"ConnectSeconds", "SessionSeconds", "ExecSeconds",
+}
+
+// Record is a CommandReturn, flattened, with the run it is from, for the jsonl and
+// csv formats. It is a stable schema: Fields may be added to the end, but never
+// changed or removed.
+type Record struct {
+	// RunID is the ID of the RunState of the run
+	RunID    string
+	Workflow string
+	// Step is the workflow command this is from, starting at 1. 0 is not a workflow.
+	Step    int
+	Host    string
+	Address string
+	Command string
+	// Status is one of the Status* consts
+	Status     string
+	ErrorType  string
+	Error      string
+	ExitCode   int
+	ExitSignal string
+	// Start and End are RFC 3339 timestamps, with nanoseconds. Empty if the command never started.
+	Start string
+	End   string
+	// Seconds is how long the command took
+	Seconds float64
+	Stdout  string
+	Stderr  string
+	// ConnectSeconds, SessionSeconds, and ExecSeconds are how long connecting, getting a
+	// session, and executing took, as CommandReturn's Connect, Session, and Exec
+	ConnectSeconds float64
+	SessionSeconds float64
+	ExecSeconds    float64
+}
+
+// Record returns the CommandReturn as a Record from the run with the ID
+func (cr *CommandReturn) Record(runID string) Record {
+	f := cr.format()
+	rec := Record{
+		RunID:      runID,
+		Workflow:   f.Workflow,
+		Host:       f.Name,
+		Address:    f.Address,
+		Command:    f.Command,
+		Status:     cr.Status(),
+		ErrorType:  f.ErrorType,
+		Error:      f.Error,
+		ExitCode:   f.ExitCode,
+		ExitSignal: f.ExitSignal,
+		Start:      timestamp(cr.Start),
+		End:        timestamp(cr.End),
+		Seconds:    cr.Duration().Seconds(),
+		Stdout:     strings.Join(f.Stdout, "\n"),
+		Stderr:     strings.Join(f.Stderr, "\n"),
+
+		ConnectSeconds: cr.Connect.Seconds(),
+		SessionSeconds: cr.Session.Seconds(),
+		ExecSeconds:    cr.Exec.Seconds(),
+	}
+	if cr.Workflow != "" {
+		rec.Step = cr.Step + 1
+	}
+	return rec
+}
+
+// ToJSON returns the Record as a line of JSON, without the line ending
+func (rec *Record) ToJSON() []byte {
+	// Only strings and numbers, so it always marshals
+	j, _ := json.Marshal(rec)
+	return j
+}
+
+// ToCSV returns the Record's fields, in the order of RecordFields, for a csv.Writer
+func (rec *Record) ToCSV() []string {
+	return []string{
+		rec.RunID, rec.Workflow, fmt.Sprint(rec.Step), rec.Host, rec.Address, rec.Command,
+		rec.Status, rec.ErrorType, rec.Error, fmt.Sprint(rec.ExitCode), rec.ExitSignal,
+		rec.Start, rec.End, csvSeconds(rec.Seconds), rec.Stdout, rec.Stderr,
+		csvSeconds(rec.ConnectSeconds), csvSeconds(rec.SessionSeconds), csvSeconds(rec.ExecSeconds),
+	}
+}
+
+// csvSeconds returns the seconds without an exponent, which spreadsheets may not understand
+func csvSeconds(secs float64) string {
+	return strconv.FormatFloat(secs, 'f', -1, 64)
+}
+
+// timestamp returns t as an RFC 3339 timestamp, with nanoseconds, or empty if it is zero
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,53 @@
+package deck
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCommandReturn_Record(t *testing.T) {
+	cr := CommandReturn{
+		HostObj:   Host{Name: "web01", Address: "10.0.0.1"},
+		Workflow:  "wf",
+		Step:      1,
+		Command:   "false",
+		Error:     fmt.Errorf("exit 1"),
+		ErrorType: ErrorExit,
+		ExitCode:  1,
+		Start:     time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC),
+	}
+	cr.End = cr.Start.Add(1500 * time.Millisecond)
+	cr.Stdout.WriteString("one\ntwo\n")
+
+	rec := cr.Record("run1")
+	if rec.RunID != "run1" || rec.Step != 2 || rec.Host != "web01" || rec.Status != StatusFailed {
+		t.Errorf("Unexpected Record: %+v\n", rec)
+	}
+	if rec.Start != "2021-06-01T10:00:00Z" || rec.End != "2021-06-01T10:00:01.5Z" || rec.Seconds != 1.5 {
+		t.Errorf("Unexpected Record times: %s %s %f\n", rec.Start, rec.End, rec.Seconds)
+	}
+	if rec.Stdout != "one\ntwo" || rec.Stderr != "" {
+		t.Errorf("Unexpected Record output: '%s' '%s'\n", rec.Stdout, rec.Stderr)
+	}
+
+	var back Record
+	if err := json.Unmarshal(rec.ToJSON(), &back); err != nil || back != rec {
+		t.Errorf("Expected Record to round-trip JSON, got %+v (%v)\n", back, err)
+	}
+
+	row := rec.ToCSV()
+	if len(row) != len(RecordFields) {
+		t.Fatalf("Expected %d CSV fields, got %d\n", len(RecordFields), len(row))
+	}
+	if row[2] != "2" || row[9] != "1" || row[13] != "1.5" {
+		t.Errorf("Unexpected CSV row: %q\n", row)
+	}
+
+	// Not a workflow, and never ran
+	cr = CommandReturn{Command: "uptime"}
+	if rec = cr.Record("run1"); rec.Step != 0 || rec.Start != "" || rec.Seconds != 0 {
+		t.Errorf("Unexpected Record for unrun command: %+v\n", rec)
+	}
+}
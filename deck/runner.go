@@ -0,0 +1,718 @@
+/*
+Package deck is the orchestration behind All Hands On Deck: Hosts and Workflows, loaded
+into a Config, and a Runner to execute a command or workflow against them, over SSH.
+
+	conf, err := deck.LoadConfigs("configs/")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	r := deck.NewRunner(conf)
+	r.Cmd = "uptime"
+	r.Filter = "Tags == web"
+	r.Auth = []ssh.AuthMethod{ssh.PublicKeys(key)}
+	r.HostKeys, _ = deck.NewHostKeyChecker(deck.HostKeyStrict, home+"/.ssh/known_hosts")
+	if err := r.Check(); err != nil {
+		log.Fatal(err)
+	}
+
+	for res := range r.Run(ctx) {
+		for _, cr := range res.CommandReturns {
+			fmt.Println(cr.ToText())
+		}
+	}
+	os.Exit(r.Summary.ExitCode)
+*/
+package deck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cognusion/semaphore"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultTimeout is how long a Runner waits for the next host to return, by default
+const DefaultTimeout = 60 * time.Second
+
+// ConnectTimeout is how long connecting to a host, and the SSH handshake, may take, if
+// nothing gives up on it sooner
+const ConnectTimeout = 30 * time.Second
+
+// stragglerWait is how long a Runner waits for the hosts that timed out to stop, once
+// they're canceled
+const stragglerWait = 5 * time.Second
+
+// Result is how a single host fared in a Run
+type Result struct {
+	Host Host
+	// Status is one of the Status* consts
+	Status string
+	// Step is the workflow command the host reached, starting at 1. 0 is none, or not a workflow.
+	Step int
+	// Workflow is the WorkflowReturn, if running a workflow
+	Workflow *WorkflowReturn
+	// CommandReturns are every command executed on the host. None if it was skipped, or timed out.
+	CommandReturns []CommandReturn
+}
+
+// Duration returns how long the host took to run the workflow, or the command. 0 if it
+// was skipped, or timed out.
+func (res *Result) Duration() time.Duration {
+	if res.Workflow != nil {
+		return res.Workflow.Duration()
+	} else if len(res.CommandReturns) > 0 {
+		return res.CommandReturns[0].Duration()
+	}
+	return 0
+}
+
+// Runner executes a command, or a workflow, against the hosts of a Config. Set the
+// options, Check, and Run.
+type Runner struct {
+	// Config is the hosts and workflows to run against
+	Config Config
+	// Cmd is the command to run, or Workflow the name of the workflow to run. One must be set.
+	Cmd      string
+	Workflow string
+
+	// User is who to connect as, unless the Host has a User
+	User string
+	// Auth are the ways to authenticate to hosts
+	Auth []ssh.AuthMethod
+	// HostKeys checks the keys of hosts. It must be set.
+	HostKeys *HostKeyChecker
+
+	// Sudo runs commands via sudo
+	Sudo bool
+	// Dnf uses dnf instead of yum for some workflow commands
+	Dnf bool
+	// DryRun goes through the motions, but never actually connects to anything
+	DryRun bool
+	// Vars are the global vars: The Config's Miscs, and any others (e.g. VAR1), for workflows
+	Vars map[string]string
+
+	// Filter limits the hosts to the ones it matches. See Filter.
+	Filter string
+	// Wave limits the hosts to the ones in the wave, if set
+	Wave int
+	// Waves runs every wave, in ascending order, halting if a wave has failures. Hosts
+	// that aren't in a wave are left out, as with Wave.
+	Waves bool
+	// WavePause is how long to pause between waves, or whether to ask. Confirmations are
+	// asked on Prompt, and read from Confirm.
+	WavePause WavePause
+	Confirm   io.Reader
+	Prompt    io.Writer
+
+	// Batch runs the hosts in rolling batches, each after the last completes. Unset uses
+	// the workflow's Batch.
+	Batch HostCount
+	// MaxFail abandons the remaining batches when more hosts than it have failed. Unset
+	// uses the workflow's MaxFail.
+	MaxFail HostCount
+	// MaxExecs is how many hosts may be executed on at once. 0 makes a good guess, from
+	// the open files limit, and -1 uses GOMAXPROCS.
+	MaxExecs int
+	// Sleep staggers the hosts of a batch, by this much each
+	Sleep time.Duration
+	// CommandTimeout is how long each command may take, unless the workflow overrides it.
+	// 0 is forever.
+	CommandTimeout time.Duration
+	// Timeout is how long to wait for the next host to return, before giving up on all of
+	// the ones that haven't, unless the workflow's MinTimeout is longer. 0 is forever.
+	Timeout time.Duration
+
+	// Retry, if set, limits the hosts to the ones in it, by HostState Key (see
+	// RunState.Failed). With Resume, workflows resume at the Step each host broke on.
+	Retry  map[string]HostState
+	Resume bool
+
+	// Stream, if set, is called with every line of output from every command (except
+	// QUIET ones, and transfers) as it arrives, from many goroutines at once. See
+	// LinePrinter.
+	Stream func(OutputLine)
+	// Sinks, if set, are given every Result as it's emitted, and Closed, in order, once
+	// the Run is over. Their errors are logged to Error. See Output.
+	Sinks []Sink
+	// Progress, if set, is called as each host returns, with how many have, of how many
+	// are being run on. Skipped hosts don't count.
+	Progress func(done, total int)
+	// Signals, if set, interrupt the Run: The first Stops it, and the second terminates
+	// the running commands, as canceling the context does.
+	Signals <-chan os.Signal
+
+	// Log is for normal output, e.g. Results and the Summary, Error is for errors, and
+	// Debug is for debugging. Any may be nil.
+	Log   *log.Logger
+	Error *log.Logger
+	Debug *log.Logger
+
+	// Summary and State are how every host fared, filled in by Run
+	Summary *Summary
+	State   *RunState
+
+	// confirmations are read from Confirm, for every wave
+	confirmations *Confirmations
+
+	stop context.CancelFunc
+	lock sync.Mutex
+}
+
+// NewRunner returns a Runner for the Config, with the Config's Miscs as its Vars, as the
+// current user, logging to stdout and stderr, with the DefaultTimeout
+func NewRunner(conf Config) *Runner {
+	r := &Runner{
+		Config:  conf,
+		Vars:    miscToMap(conf.Miscs),
+		Timeout: DefaultTimeout,
+		Confirm: os.Stdin,
+		Prompt:  os.Stderr,
+		Log:     log.New(os.Stdout, "", 0),
+		Error:   log.New(os.Stderr, "", 0),
+		Debug:   log.New(ioutil.Discard, "", 0),
+	}
+	if u, err := user.Current(); err == nil {
+		r.User = u.Username
+	}
+	return r
+}
+
+// Check validates the Runner, and its Config, returning an error if it can't Run
+func (r *Runner) Check() error {
+	if err := r.Config.Check(); err != nil {
+		return err
+	}
+	if _, err := cachedFilter(r.Filter); err != nil {
+		return fmt.Errorf("filter: %s", err)
+	}
+
+	if r.Cmd != "" && r.Workflow != "" {
+		return fmt.Errorf("command and workflow are mutually exclusive")
+	} else if r.Cmd == "" && r.Workflow == "" {
+		return fmt.Errorf("command or workflow must be set")
+	}
+
+	if r.Workflow != "" {
+		wfi := r.Config.WorkflowIndex(r.Workflow)
+		if wfi < 0 {
+			return fmt.Errorf("workflow '%s' does not exist in specified configs", r.Workflow)
+		} else if r.Config.Workflows[wfi].MustChain {
+			return fmt.Errorf("workflow '%s' must be used in a chain", r.Workflow)
+		}
+		for _, v := range r.Config.Workflows[wfi].VarsRequired {
+			if _, ok := r.Vars[v]; !ok {
+				return fmt.Errorf("workflow '%s' requires unset var '%s'", r.Workflow, v)
+			}
+		}
+	}
+
+	if r.Waves && r.Wave > 0 {
+		return fmt.Errorf("wave and waves are mutually exclusive")
+	}
+	if r.HostKeys == nil {
+		return fmt.Errorf("host key checking must be set")
+	}
+	return nil
+}
+
+// Hosts returns the hosts a Run runs on, in the order it runs them
+func (r *Runner) Hosts() (hosts []Host) {
+	waves, _ := r.plan()
+	for _, w := range waves {
+		hosts = append(hosts, w...)
+	}
+	return
+}
+
+// retrying returns the state the Host is being retried from, and true, or false if it
+// isn't being retried
+func (r *Runner) retrying(h Host) (HostState, bool) {
+	hs, ok := r.Retry[newHostState(h, "", 0).Key()]
+	return hs, ok
+}
+
+// plan returns the hosts to run on, in waves. Without Waves, everything is one big
+// wave. Hosts that aren't in a wave, when there are Waves, are returned separately.
+func (r *Runner) plan() (waves [][]Host, skipped []Host) {
+	wfIndex := -1
+	if r.Workflow != "" {
+		wfIndex = r.Config.WorkflowIndex(r.Workflow)
+	}
+
+	hosts := r.Config.FilteredHostList(r.Filter, r.Wave, wfIndex)
+	if r.Retry != nil {
+		var retrying []Host
+		for _, h := range hosts {
+			if _, ok := r.retrying(h); ok {
+				retrying = append(retrying, h)
+			}
+		}
+		hosts = retrying
+	}
+
+	if !r.Waves {
+		return [][]Host{hosts}, nil
+	}
+	return groupWaves(hosts)
+}
+
+// workflow returns an initialized copy of the Workflow to run, or nil if we're
+// running a command. The error is from initializing it, and isn't fatal.
+func (r *Runner) workflow() (*Workflow, error) {
+	if r.Workflow == "" {
+		return nil, nil
+	}
+
+	// Init mangles Commands, so we need our own
+	conf := r.Config
+	conf.Workflows = append([]Workflow(nil), conf.Workflows...)
+	wfi := conf.WorkflowIndex(r.Workflow)
+	wf := &conf.Workflows[wfi]
+	wf.Commands = append([]string(nil), wf.Commands...)
+	if r.Dnf {
+		wf.Dnf = true
+	}
+
+	return wf, conf.InitWorkflow(wfi, r.Vars)
+}
+
+// Run runs the command, or workflow, against the hosts, sending a Result for every
+// host as it returns, or is skipped, or times out. The channel is closed when the
+// run is over, at which point Summary and State are complete. The Runner must have
+// been Checked: If it can't Run, the error is logged to Error, and the channel is
+// closed without any Results.
+//
+// Cancelling the context terminates every command in progress. See Stop to stop more
+// gently.
+func (r *Runner) Run(ctx context.Context) <-chan Result {
+	r.Summary = &Summary{}
+	started := time.Now()
+	r.State = &RunState{ID: newRunID(started), Started: started, Workflow: r.Workflow, Command: r.Cmd}
+
+	stopped, stop := context.WithCancel(ctx)
+	r.lock.Lock()
+	r.stop = stop
+	r.lock.Unlock()
+
+	results := make(chan Result, 100)
+	go r.run(ctx, stopped, results)
+	return results
+}
+
+// Stop stops the Run from starting on any more hosts. The hosts being run on finish,
+// and the rest are skipped.
+func (r *Runner) Stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stop != nil {
+		r.stop()
+	}
+}
+
+// run is Run. Nothing more is started on a host once stopped is done, and everything
+// in progress is terminated once ctx is.
+func (r *Runner) run(ctx, stopped context.Context, results chan<- Result) {
+	defer close(results)
+
+	if err := r.Check(); err != nil {
+		r.errorf("%s\n", err)
+		r.State.Ended = time.Now()
+		return
+	}
+
+	// Everything hangs off of this, so when we bail, everything bails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if r.Signals != nil {
+		go r.interrupt(ctx, cancel)
+	}
+
+	wf, err := r.workflow()
+	if err != nil {
+		r.errorf("Error initializing workflow '%s': %s\n", r.Workflow, err)
+	}
+
+	// Rolling execution, and timeouts: Ours trump the workflow's
+	timeout, batchSize, maxFail := r.Timeout, r.Batch, r.MaxFail
+	if wf != nil {
+		if min := time.Duration(wf.MinTimeout) * time.Second; timeout > 0 && min > timeout {
+			timeout = min
+		}
+		// Check()ed
+		if !batchSize.IsSet() {
+			batchSize, _ = ParseHostCount(wf.Batch)
+		}
+		if !maxFail.IsSet() {
+			maxFail, _ = ParseHostCount(wf.MaxFail)
+		}
+	}
+
+	// SSH Config for a given Host
+	sshConfig := func(h Host) *ssh.ClientConfig {
+		// Handle alternate usernames
+		configUser := r.User
+		if h.User != "" {
+			configUser = h.User
+		}
+
+		return &ssh.ClientConfig{
+			User:              configUser,
+			Auth:              r.Auth,
+			HostKeyCallback:   r.HostKeys.Callback(h),
+			HostKeyAlgorithms: r.HostKeys.Algorithms(h),
+			Timeout:           ConnectTimeout,
+		}
+	}
+
+	// Bastion connections are shared by all the hosts that jump through them
+	jumps := NewJumpHosts(r.Config.Hosts, sshConfig)
+	jumps.Debug = r.Debug
+	defer jumps.Close()
+
+	// Every command on a host shares one connection
+	conns := NewConnections()
+	defer conns.Close()
+
+	max := r.MaxExecs
+	if max == 0 {
+		// Autoconfig max execs
+		max = r.saneMaxLimit()
+	} else if max == -1 {
+		// Autoconfig based on GOMAXPROCS (lame)
+		max = runtime.GOMAXPROCS(0)
+	}
+
+	// To keep things sane, we gate the number of goros that can be executing remote
+	// commands to a limit.
+	r.debugf("Max simultaneous execs set to %d\n", max)
+	sem := semaphore.NewSemaphore(max)
+
+	for _, sink := range r.Sinks {
+		if err := sink.Open(r.State); err != nil {
+			r.errorf("Error %s\n", err)
+		}
+	}
+
+	hostWaves, noWave := r.plan()
+	if len(noWave) > 0 {
+		// Not work we skipped, just not work we were given, as with Wave
+		var names []string
+		for _, h := range noWave {
+			names = append(names, h.Name)
+		}
+		r.errorf("Leaving out %d hosts that aren't in a wave: %s\n", len(names), names)
+	}
+
+	var hosts []Host
+	for _, w := range hostWaves {
+		hosts = append(hosts, w...)
+	}
+	r.debugf("FilteredHostCount: %d\n", len(hosts))
+
+	// Hosts that had commands time out, and where, and how many hosts have been run on
+	var (
+		timedOut []string
+		ran      int
+	)
+
+	// emit records how the host fared, and sends it along
+	emit := func(res Result) {
+		r.Summary.Add(res.Host.ID(), res.Status)
+		if d := res.Duration(); d > 0 {
+			r.Summary.Took(res.Host.ID(), d)
+		}
+		r.State.Add(res.Host, res.Status, res.Step)
+
+		for _, c := range res.CommandReturns {
+			if c.TimedOut && res.Workflow != nil {
+				timedOut = append(timedOut, fmt.Sprintf("%s at step %d: %s", res.Host.Name, c.Step+1, c.Command))
+			} else if c.TimedOut {
+				timedOut = append(timedOut, fmt.Sprintf("%s: %s", res.Host.Name, c.Command))
+			}
+		}
+		if res.Status != StatusSkipped && r.Progress != nil {
+			ran++
+			r.Progress(ran, len(hosts))
+		}
+		for _, sink := range r.Sinks {
+			if err := sink.Add(res); err != nil {
+				r.errorf("Error %s\n", err)
+			}
+		}
+		results <- res
+	}
+
+	// Everything that's dispatched returns here, even after we've stopped listening
+	var wg sync.WaitGroup
+	returned := make(chan Result, len(hosts))
+
+	// dispatch starts executing on the host, wait into the batch
+	dispatch := func(host Host, wait time.Duration) {
+		r.debugf("Host: %s\n", host.Name)
+
+		com := Command{Host: host, SSHConfig: sshConfig(host), Jumps: jumps, Conns: conns, Sudo: r.Sudo, Timeout: r.CommandTimeout, Runner: r}
+
+		// Pick up where we left off?
+		var start int
+		if hs, ok := r.retrying(host); ok && wf != nil && r.Resume && hs.Step > 0 {
+			start = hs.Step - 1
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Sleeeeep
+			r.debugf("Sleeping for %s\n", wait)
+			select {
+			case <-time.After(wait):
+			case <-stopped.Done():
+			}
+
+			sem.Lock()
+			defer sem.Unlock()
+
+			res := Result{Host: host}
+			if stopped.Err() != nil {
+				// Stopped before it was our turn
+				res.Status = StatusSkipped
+				returned <- res
+				return
+			}
+			conns.Hold(&com)
+			defer conns.Release(&com)
+
+			if wf != nil {
+				// Workflows are configured sets of commands and logics, with sets of returns
+				wr := wf.ExecContextFrom(ctx, com, start)
+				res.Workflow = &wr
+				res.CommandReturns = wr.CommandReturns
+				res.Status = wr.Status()
+				res.Step = wr.Step + 1
+			} else {
+				// Commands are single directives, with single returns
+				com.Cmd = r.Cmd
+				cr := com.ExecContext(ctx)
+				res.CommandReturns = []CommandReturn{cr}
+				res.Status = cr.Status()
+			}
+			returned <- res
+		}()
+	}
+
+	// collect waits for the batch to return, returning how many failed, and false if
+	// we timed out waiting
+	collect := func(batch []Host) (failed int, ok bool) {
+		pending := make(map[string]bool)
+		for _, h := range batch {
+			pending[newHostState(h, "", 0).Key()] = true
+		}
+
+		for range batch {
+			var after <-chan time.Time
+			if timeout > 0 {
+				after = time.After(timeout)
+			}
+
+			select {
+			case res := <-returned:
+				pending[newHostState(res.Host, "", 0).Key()] = false // returned is good enough for this
+				if res.Workflow != nil && !res.Workflow.Completed {
+					r.errorf("Workflow %s did not fully complete\n", res.Workflow.Name)
+				}
+				if res.Status != StatusSucceeded && res.Status != StatusSkipped {
+					failed++
+				}
+				emit(res)
+			case <-after:
+				var badHosts []string
+				for _, h := range batch {
+					if pending[newHostState(h, "", 0).Key()] {
+						badHosts = append(badHosts, h.Name)
+						emit(Result{Host: h, Status: StatusTimedOut})
+					}
+				}
+				r.errorf("Operation timed out! The following hosts haven't returned: %s\n", badHosts)
+				return failed, false
+			}
+		}
+		return failed, true
+	}
+
+	// Each batch runs after the last one completes. Without Batch, everything is one
+	// big batch.
+	var failures, done int
+	halted, abandoned := false, false
+	for w, waveHosts := range hostWaves {
+		if r.Waves && w > 0 {
+			if r.WavePause.Duration > 0 {
+				r.debugf("Pausing %s before wave %d\n", r.WavePause.Duration, waveHosts[0].Wave)
+			}
+			if r.WavePause.Confirm && r.confirmations == nil {
+				r.confirmations = NewConfirmations(r.Confirm)
+			}
+			if !r.WavePause.Wait(stopped, waveHosts[0].Wave, r.confirmations, r.Prompt) {
+				r.errorf("Not continuing with wave %d\n", waveHosts[0].Wave)
+				break
+			}
+		}
+
+		waveFailures, waveDone := 0, 0
+		hostBatches := batches(waveHosts, batchSize.Of(len(waveHosts)))
+		for b, batch := range hostBatches {
+			if len(hostBatches) > 1 {
+				r.debugf("Batch %d of %d: %d hosts\n", b+1, len(hostBatches), len(batch))
+			}
+
+			for i, host := range batch {
+				dispatch(host, time.Duration(i)*r.Sleep)
+			}
+
+			failed, ok := collect(batch)
+			done += len(batch)
+			if !ok {
+				abandoned = true
+				break
+			}
+			failures += failed
+			waveFailures += failed
+			waveDone += len(batch)
+
+			if maxFail.IsSet() && failures > maxFail.Of(len(hosts)) && done < len(hosts) {
+				r.errorf("%d hosts failed, more than the max-fail of %s\n", failures, maxFail)
+				halted = true
+				break
+			} else if stopped.Err() != nil {
+				halted = true
+				break
+			}
+		}
+
+		if r.Waves {
+			r.errorf("Wave %d complete: %d hosts, %d succeeded, %d failed\n", waveHosts[0].Wave, waveDone, waveDone-waveFailures, waveFailures)
+			if waveFailures > 0 && done < len(hosts) {
+				r.errorf("Wave %d had failures, halting\n", waveHosts[0].Wave)
+				halted = true
+			}
+		}
+
+		if halted || abandoned {
+			break
+		}
+	}
+
+	if done < len(hosts) {
+		var skipped []string
+		for _, h := range hosts[done:] {
+			skipped = append(skipped, h.Name)
+			emit(Result{Host: h, Status: StatusSkipped})
+		}
+		r.errorf("Not running on the remaining %d hosts: %s\n", len(skipped), skipped)
+	}
+
+	if abandoned {
+		// Don't wait on the stragglers for long, but give them a chance to notice they're
+		// canceled before the connections they're using are closed out from under them
+		cancel()
+		finished := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(stragglerWait):
+			r.errorf("Gave up waiting for the hosts that timed out to stop\n")
+		}
+	} else {
+		wg.Wait()
+	}
+
+	if len(timedOut) > 0 {
+		r.errorf("The following hosts timed out:\n\t%s\n", strings.Join(timedOut, "\n\t"))
+	}
+
+	r.State.Ended = time.Now()
+	for _, sink := range r.Sinks {
+		if err := sink.Close(r.State, r.Summary); err != nil {
+			r.errorf("Error %s\n", err)
+		}
+	}
+}
+
+// interrupt Stops the Run on the first Signal, and cancels it on the second, until
+// ctx is done
+func (r *Runner) interrupt(ctx context.Context, cancel context.CancelFunc) {
+	select {
+	case s := <-r.Signals:
+		r.errorf("Caught %s: Not starting on any more hosts, and waiting for the running ones to finish. Again to terminate them.\n", s)
+		r.Stop()
+	case <-ctx.Done():
+		return
+	}
+
+	select {
+	case s := <-r.Signals:
+		r.errorf("Caught %s again: Terminating the running commands\n", s)
+		cancel()
+	case <-ctx.Done():
+	}
+}
+
+// logf logs to l, if there is one
+func logf(l *log.Logger, format string, v ...interface{}) {
+	if l != nil {
+		l.Printf(format, v...)
+	}
+}
+
+// debugf logs to the Runner's Debug log. The Runner may be nil.
+func (r *Runner) debugf(format string, v ...interface{}) {
+	if r != nil {
+		logf(r.Debug, format, v...)
+	}
+}
+
+// errorf logs to the Runner's Error log. The Runner may be nil.
+func (r *Runner) errorf(format string, v ...interface{}) {
+	if r != nil {
+		logf(r.Error, format, v...)
+	}
+}
+
+// vars returns the Runner's Vars. The Runner may be nil.
+func (r *Runner) vars() map[string]string {
+	if r == nil {
+		return nil
+	}
+	return r.Vars
+}
+
+// stream returns a lineWriter that Streams the Command's output, or nil if the
+// Runner isn't Streaming. The Runner may be nil.
+func (r *Runner) stream(c *Command, cmd string, stderr bool) *lineWriter {
+	if r == nil || r.Stream == nil || c.Quiet {
+		return nil
+	}
+	return &lineWriter{fn: func(line string) {
+		r.Stream(OutputLine{Host: c.Host, Command: cmd, Step: c.Step, Stderr: stderr, Line: line})
+	}}
+}
+
+// dryRun returns true if the Runner is doing a DryRun. The Runner may be nil.
+func (r *Runner) dryRun() bool {
+	return r != nil && r.DryRun
+}
@@ -0,0 +1,176 @@
+package deck
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRunner_Check(t *testing.T) {
+	conf := Config{
+		Workflows: []Workflow{
+			{Name: "top", Commands: []string{"%%bottom"}, VarsRequired: []string{"VAR1"}},
+			{Name: "bottom", MustChain: true, Commands: []string{"uptime"}},
+		},
+	}
+	hkc, err := NewHostKeyChecker(HostKeyOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, set := range map[string]func(r *Runner){
+		"nothing":        func(r *Runner) {},
+		"both":           func(r *Runner) { r.Cmd = "uptime"; r.Workflow = "top" },
+		"missing":        func(r *Runner) { r.Workflow = "NOPE" },
+		"must chain":     func(r *Runner) { r.Workflow = "bottom" },
+		"required var":   func(r *Runner) { r.Workflow = "top" },
+		"bad filter":     func(r *Runner) { r.Cmd = "uptime"; r.Filter = "Name ==" },
+		"wave and waves": func(r *Runner) { r.Cmd = "uptime"; r.Wave = 1; r.Waves = true },
+		"no host keys":   func(r *Runner) { r.Cmd = "uptime"; r.HostKeys = nil },
+	} {
+		r := NewRunner(conf)
+		r.HostKeys = hkc
+		set(r)
+		if err := r.Check(); err == nil {
+			t.Errorf("Expected error for %s, got nil\n", name)
+		}
+	}
+
+	r := NewRunner(conf)
+	r.HostKeys = hkc
+	r.Workflow = "top"
+	r.Vars["VAR1"] = "set"
+	if err := r.Check(); err != nil {
+		t.Errorf("Expected valid Runner, got: %s\n", err)
+	}
+}
+
+func TestRunner_Hosts(t *testing.T) {
+	r := NewRunner(Config{
+		Hosts: []Host{
+			{Name: "one", Wave: 2, Tags: []string{"web"}},
+			{Name: "two", Wave: 1, Tags: []string{"web"}},
+			{Name: "three", Tags: []string{"web"}},
+			{Name: "four", Wave: 1, Tags: []string{"db"}},
+			{Name: "five", Wave: 1, Tags: []string{"web"}, Offline: true},
+		},
+	})
+	r.Filter = "Tags == web"
+
+	names := func(hosts []Host) (n []string) {
+		for _, h := range hosts {
+			n = append(n, h.Name)
+		}
+		return
+	}
+
+	if h := names(r.Hosts()); len(h) != 3 || h[0] != "one" || h[1] != "two" || h[2] != "three" {
+		t.Errorf("Expected [one two three], got %v\n", h)
+	}
+
+	r.Waves = true
+	if h := names(r.Hosts()); len(h) != 2 || h[0] != "two" || h[1] != "one" {
+		t.Errorf("Expected waves [two one], got %v\n", h)
+	}
+
+	state := &RunState{}
+	state.Add(Host{Name: "one"}, StatusFailed, 0)
+	r.Retry = state.Failed()
+	if h := names(r.Hosts()); len(h) != 1 || h[0] != "one" {
+		t.Errorf("Expected retry [one], got %v\n", h)
+	}
+}
+
+func TestRunner_RetrySameName(t *testing.T) {
+	hosts := []Host{
+		{Name: "web", Address: "10.0.0.1"},
+		{Name: "web", Address: "10.0.0.2"},
+		{Name: "web", Address: "10.0.0.3"},
+	}
+	r := NewRunner(Config{Hosts: hosts})
+
+	state := &RunState{}
+	state.Add(hosts[0], StatusSucceeded, 2)
+	state.Add(hosts[1], StatusFailed, 2)
+	state.Add(hosts[2], StatusSucceeded, 2)
+	r.Retry = state.Failed()
+
+	if h := r.Hosts(); len(h) != 1 || h[0].Address != "10.0.0.2" {
+		t.Errorf("Expected retry of only web at 10.0.0.2, got %v\n", h)
+	}
+}
+
+func TestRunner_SummarySameName(t *testing.T) {
+	r := NewRunner(Config{Hosts: []Host{
+		{Name: "web", Address: "127.0.0.1", Port: 1},
+		{Name: "web", Address: "127.0.0.2", Port: 1},
+	}})
+	r.Cmd = "true"
+	r.DryRun = true
+	r.HostKeys, _ = NewHostKeyChecker(HostKeyOff)
+	r.Error = nil
+
+	for range r.Run(context.Background()) {
+	}
+	if s := r.Summary.Succeeded; len(s) != 2 || s[0] == s[1] {
+		t.Errorf("Expected both hosts named web, told apart, got %v\n", s)
+	}
+}
+
+// recordingSink is a Sink that records what it's given
+type recordingSink struct {
+	calls []string
+}
+
+func (s *recordingSink) Open(state *RunState) error {
+	s.calls = append(s.calls, "open")
+	return nil
+}
+
+func (s *recordingSink) Add(res Result) error {
+	s.calls = append(s.calls, res.Host.Name)
+	return nil
+}
+
+func (s *recordingSink) Close(state *RunState, summary *Summary) error {
+	if state.Ended.IsZero() || summary.Hosts() != 2 {
+		return fmt.Errorf("closed before the run was over")
+	}
+	s.calls = append(s.calls, "close")
+	return nil
+}
+
+func TestRunner_Sinks(t *testing.T) {
+	r := NewRunner(Config{Hosts: []Host{
+		{Name: "one", Address: "127.0.0.1", Port: 1},
+		{Name: "two", Address: "127.0.0.2", Port: 1},
+	}})
+	r.Cmd = "true"
+	r.DryRun = true
+	r.HostKeys, _ = NewHostKeyChecker(HostKeyOff)
+
+	var errs bytes.Buffer
+	r.Error = log.New(&errs, "", 0)
+
+	sink := &recordingSink{}
+	r.Sinks = []Sink{sink}
+
+	var progress []string
+	r.Progress = func(done, total int) {
+		progress = append(progress, fmt.Sprintf("%d/%d", done, total))
+	}
+
+	for range r.Run(context.Background()) {
+	}
+
+	// The hosts may return in either order
+	if c := sink.calls; len(c) != 4 || c[0] != "open" || c[1] == c[2] || c[3] != "close" {
+		t.Errorf("Expected open, both hosts, and close, got %v (%s)\n", c, errs.String())
+	}
+	if p := strings.Join(progress, " "); p != "1/2 2/2" {
+		t.Errorf("Expected progress '1/2 2/2', got '%s'\n", p)
+	}
+}
@@ -0,0 +1,352 @@
+//go:build !windows && !plan9
+
+package deck
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestRunner returns a Runner for the hosts, all of which are the test server, with
+// the host var "ok" set to "yes"
+func newTestRunner(t *testing.T, s *testSSHServer, names ...string) *Runner {
+	var conf Config
+	for _, n := range names {
+		h := s.Host
+		h.Name = n
+		h.Vars = map[string]string{"ok": "yes"}
+		conf.AddHost(h)
+	}
+
+	hkc, err := NewHostKeyChecker(HostKeyOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRunner(conf)
+	r.User = "test"
+	r.Auth = []ssh.AuthMethod{ssh.Password("")}
+	r.HostKeys = hkc
+	r.Error = nil
+	return r
+}
+
+func TestRunner_RunCommand(t *testing.T) {
+	s := newTestSSHServer(t)
+	r := newTestRunner(t, s, "one", "two", "three")
+	r.Cmd = "echo hello"
+
+	var count int
+	for res := range r.Run(context.Background()) {
+		count++
+		if res.Status != StatusSucceeded || len(res.CommandReturns) != 1 || res.Workflow != nil {
+			t.Errorf("Expected one successful command on %s, got %+v\n", res.Host.Name, res)
+		} else if out := res.CommandReturns[0].StdoutString(false); out != "hello\n" {
+			t.Errorf("Expected 'hello' from %s, got '%s'\n", res.Host.Name, out)
+		}
+	}
+
+	if count != 3 {
+		t.Errorf("Expected 3 results, got %d\n", count)
+	}
+	if len(r.Summary.Succeeded) != 3 || r.Summary.ExitCode != ExitOK {
+		t.Errorf("Expected 3 succeeded, and exit 0, got %s\n", r.Summary.ToText())
+	}
+	if len(r.State.Hosts) != 3 || r.State.Ended.IsZero() || r.State.ID == "" {
+		t.Errorf("Expected 3 hosts in a finished state, got %+v\n", r.State)
+	}
+}
+
+func TestRunner_RunWorkflow(t *testing.T) {
+	s := newTestSSHServer(t)
+	r := newTestRunner(t, s, "one", "two")
+	r.Config.Hosts[1].Vars["ok"] = "no"
+	r.Config.Workflows = []Workflow{
+		{Name: "flow", Commands: []string{"echo %VAR1%", "test '%host.ok%' = yes", "echo done"}},
+	}
+	r.Workflow = "flow"
+	r.Vars["VAR1"] = "hi"
+
+	results := make(map[string]Result)
+	for res := range r.Run(context.Background()) {
+		results[res.Host.Name] = res
+	}
+
+	if res := results["one"]; res.Status != StatusSucceeded || res.Workflow == nil || !res.Workflow.Completed || len(res.CommandReturns) != 3 {
+		t.Errorf("Expected one to complete, got %+v\n", res)
+	} else if out := res.CommandReturns[0].StdoutString(false); out != "hi\n" {
+		t.Errorf("Expected global var to be expanded, got '%s'\n", out)
+	}
+
+	if res := results["two"]; res.Status != StatusFailed || res.Step != 2 || len(res.CommandReturns) != 2 {
+		t.Errorf("Expected two to fail at step 2, got %+v\n", res)
+	}
+	if r.Summary.ExitCode != ExitFailed {
+		t.Errorf("Expected exit %d, got %d\n", ExitFailed, r.Summary.ExitCode)
+	}
+	if r.Config.Workflows[0].Commands[0] != "echo %VAR1%" {
+		t.Errorf("Expected the Config's workflow to be untouched, got '%s'\n", r.Config.Workflows[0].Commands[0])
+	}
+}
+
+func TestRunner_RunWaves(t *testing.T) {
+	s := newTestSSHServer(t)
+	r := newTestRunner(t, s, "one", "two", "three", "none")
+	r.Config.Hosts[0].Wave = 1
+	r.Config.Hosts[1].Wave = 2
+	r.Config.Hosts[1].Vars["ok"] = "no"
+	r.Config.Hosts[2].Wave = 3
+	r.Config.Workflows = []Workflow{
+		{Name: "flow", Commands: []string{"test '%host.ok%' = yes"}},
+	}
+	r.Workflow = "flow"
+	r.Waves = true
+
+	statuses := make(map[string]string)
+	for res := range r.Run(context.Background()) {
+		statuses[res.Host.Name] = res.Status
+	}
+
+	for name, status := range map[string]string{"one": StatusSucceeded, "two": StatusFailed, "three": StatusSkipped} {
+		if statuses[name] != status {
+			t.Errorf("Expected %s to be %s, got '%s'\n", name, status, statuses[name])
+		}
+	}
+	if _, ok := statuses["none"]; ok || len(r.State.Hosts) != 3 {
+		t.Errorf("Expected the host not in a wave to be left out, got %v\n", statuses)
+	}
+	if r.Summary.ExitCode != ExitFailed|ExitSkipped {
+		t.Errorf("Expected exit %d, got %d\n", ExitFailed|ExitSkipped, r.Summary.ExitCode)
+	}
+
+	// Every wave succeeding is success, regardless of the host that isn't in one
+	r.Config.Hosts[1].Vars["ok"] = "yes"
+	for range r.Run(context.Background()) {
+	}
+	if r.Summary.ExitCode != ExitOK || len(r.Summary.Succeeded) != 3 {
+		t.Errorf("Expected exit %d, with 3 succeeded, got %s\n", ExitOK, r.Summary.ToText())
+	}
+}
+
+func TestRunner_Stop(t *testing.T) {
+	s := newTestSSHServer(t)
+	r := newTestRunner(t, s, "one", "two", "three")
+	r.Cmd = "sleep 0.5"
+	r.MaxExecs = 1
+
+	// One at a time, so only the first is running when we stop
+	statuses := make(map[string]int)
+	results := r.Run(context.Background())
+	time.Sleep(200 * time.Millisecond)
+	r.Stop()
+	for res := range results {
+		statuses[res.Status]++
+	}
+
+	if statuses[StatusSucceeded] != 1 || statuses[StatusSkipped] != 2 {
+		t.Errorf("Expected 1 succeeded, and 2 skipped, got %v\n", statuses)
+	}
+}
+
+func TestRunner_Cancel(t *testing.T) {
+	s := newTestSSHServer(t)
+	r := newTestRunner(t, s, "one", "two")
+	r.Cmd = "sleep 10"
+	// One at a time, so the second never starts
+	r.MaxExecs = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	results := r.Run(ctx)
+	time.Sleep(200 * time.Millisecond)
+	r.Stop()
+	cancel()
+
+	statuses := make(map[string]int)
+	for res := range results {
+		statuses[res.Status]++
+		if res.Status == StatusFailed && (len(res.CommandReturns) != 1 || res.CommandReturns[0].ErrorType != ErrorCanceled) {
+			t.Errorf("Expected the running command to be canceled, got %+v\n", res.CommandReturns)
+		}
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected the running command to be terminated, but it took %s\n", time.Since(start))
+	}
+	if statuses[StatusFailed] != 1 || statuses[StatusSkipped] != 1 {
+		t.Errorf("Expected 1 failed, and 1 skipped, got %v\n", statuses)
+	}
+}
+
+func TestRunner_Stream(t *testing.T) {
+	s := newTestSSHServer(t)
+	r := newTestRunner(t, s, "one", "two")
+	r.Cmd = "echo out; echo err >&2; printf partial"
+
+	var (
+		lines []OutputLine
+		lock  sync.Mutex
+	)
+	r.Stream = func(l OutputLine) {
+		lock.Lock()
+		defer lock.Unlock()
+		lines = append(lines, l)
+	}
+
+	for res := range r.Run(context.Background()) {
+		if res.Status != StatusSucceeded || res.CommandReturns[0].StdoutString(false) != "out\npartial" {
+			t.Errorf("Expected buffered output too, got %+v\n", res)
+		}
+	}
+
+	got := make(map[string]int)
+	for _, l := range lines {
+		got[fmt.Sprintf("%s %v %s", l.Host.Name, l.Stderr, l.Line)]++
+	}
+	for _, host := range []string{"one", "two"} {
+		for _, want := range []string{host + " false out", host + " true err", host + " false partial"} {
+			if got[want] != 1 {
+				t.Errorf("Expected '%s' once, got %v\n", want, got)
+			}
+		}
+	}
+	if len(lines) != 6 {
+		t.Errorf("Expected 6 lines, got %d\n", len(lines))
+	}
+}
+
+func TestRunner_RunTimeout(t *testing.T) {
+	s := newTestSSHServer(t)
+	r := newTestRunner(t, s, "one")
+	r.Cmd = "sleep 10"
+	r.Timeout = 200 * time.Millisecond
+
+	start := time.Now()
+	for res := range r.Run(context.Background()) {
+		if res.Status != StatusTimedOut {
+			t.Errorf("Expected %s timed out, got %s\n", res.Host.Name, res.Status)
+		}
+	}
+
+	// The straggler was canceled, and waited for, but not for long
+	if took := time.Since(start); took > stragglerWait {
+		t.Errorf("Expected the run to end soon after timing out, took %s\n", took)
+	}
+	if len(r.Summary.TimedOut) != 1 {
+		t.Errorf("Expected 1 timed out, got %s\n", r.Summary.ToText())
+	}
+}
+
+func TestRunner_RunSharedAddress(t *testing.T) {
+	s := newTestSSHServer(t)
+	r := newTestRunner(t, s, "slow", "fast", "other")
+	r.Config.Hosts[0].Vars["delay"] = "0.5"
+	r.Config.Hosts[1].Vars["delay"] = "0"
+	r.Config.Hosts[2].Vars["delay"] = "0"
+	r.Config.Hosts[2].User = "other"
+	r.Config.Workflows = []Workflow{
+		{Name: "flow", Commands: []string{"sleep %host.delay%; echo done"}},
+	}
+	r.Workflow = "flow"
+	r.MaxExecs = 3
+
+	// fast and other finish, and let go of their connections, while slow is still going
+	for res := range r.Run(context.Background()) {
+		if res.Status != StatusSucceeded {
+			t.Errorf("Expected %s to succeed, got %s: %+v\n", res.Host.Name, res.Status, res.CommandReturns)
+		}
+	}
+
+	// One connection per user
+	if c := atomic.LoadInt32(&s.Conns); c != 2 {
+		t.Errorf("Expected 2 connections, got %d\n", c)
+	}
+}
+
+func TestRunner_RunJumpUnreachable(t *testing.T) {
+	s := newTestSSHServer(t)
+	r := newTestRunner(t, s, "bastion", "behind", "down")
+	r.Config.Hosts[1].Jump = "bastion"
+	r.Config.Hosts[2].Jump = "bastion"
+	r.Config.Hosts[2].Port = 1
+	r.Config.Workflows = []Workflow{
+		{Name: "flow", Commands: []string{"sleep 0.5; echo done"}},
+	}
+	r.Workflow = "flow"
+	r.Filter = "Name != bastion"
+	r.MaxExecs = 2
+
+	statuses := make(map[string]string)
+	for res := range r.Run(context.Background()) {
+		statuses[res.Host.Name] = res.Status
+	}
+
+	if statuses["behind"] != StatusSucceeded || statuses["down"] != StatusUnreachable {
+		t.Errorf("Expected behind to succeed, and down to be unreachable, got %v\n", statuses)
+	}
+	// One to the bastion, and one through it
+	if c := atomic.LoadInt32(&s.Conns); c != 2 {
+		t.Errorf("Expected 2 connections, got %d\n", c)
+	}
+}
+
+func TestRunner_Signals(t *testing.T) {
+	s := newTestSSHServer(t)
+	r := newTestRunner(t, s, "one", "two")
+	r.Cmd = "sleep 10"
+	// One at a time, so the second never starts
+	r.MaxExecs = 1
+
+	sigs := make(chan os.Signal, 2)
+	r.Signals = sigs
+
+	start := time.Now()
+	results := r.Run(context.Background())
+	time.Sleep(200 * time.Millisecond)
+	sigs <- os.Interrupt
+	sigs <- os.Interrupt
+
+	statuses := make(map[string]int)
+	for res := range results {
+		statuses[res.Status]++
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected the running command to be terminated, but it took %s\n", time.Since(start))
+	}
+	if statuses[StatusFailed] != 1 || statuses[StatusSkipped] != 1 {
+		t.Errorf("Expected 1 failed, and 1 skipped, got %v\n", statuses)
+	}
+}
+
+func TestRunner_CommandTimedOut(t *testing.T) {
+	s := newTestSSHServer(t)
+	r := newTestRunner(t, s, "one")
+	r.Cmd = "sleep 10"
+	r.CommandTimeout = 200 * time.Millisecond
+
+	var errs bytes.Buffer
+	r.Error = log.New(&errs, "", 0)
+
+	for res := range r.Run(context.Background()) {
+		if res.Status != StatusTimedOut {
+			t.Errorf("Expected %s timed out, got %s\n", res.Host.Name, res.Status)
+		}
+	}
+
+	if !strings.Contains(errs.String(), "The following hosts timed out:\n\tone: sleep 10\n") {
+		t.Errorf("Expected the timed out command to be reported, got '%s'\n", errs.String())
+	}
+}
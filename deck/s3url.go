@@ -1,6 +1,6 @@
 //go:build go1.4
 
-package main
+package deck
 
 import (
 	"crypto/hmac"
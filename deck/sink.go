@@ -0,0 +1,289 @@
+package deck
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// Sink is somewhere the Results of a Run go: The Sink is Opened with the RunState as the
+// Run starts, each Result is Added as it's emitted, and the Sink is Closed with the
+// finished RunState and Summary once the Run is over. Sinks are for one Run. See
+// Runner.Sinks.
+type Sink interface {
+	Open(state *RunState) error
+	Add(res Result) error
+	Close(state *RunState, summary *Summary) error
+}
+
+// Output formats
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatXML      = "xml"
+	FormatJSONL    = "jsonl"
+	FormatCSV      = "csv"
+	FormatTemplate = "template"
+)
+
+// Output is a Sink that writes every CommandReturn to Log as it arrives, in Format,
+// and the Summary once the Run is over. Grouped, the distinct results are written
+// once the Run is over instead, with the hosts that had them. The Summary of the
+// record formats, and template, goes to Error, to keep Log nothing but them.
+type Output struct {
+	// Format is one of the Format* consts. FormatTemplate requires Template.
+	Format   string
+	Template *Template
+	// Group, if set, is "hosts" to list the hosts with each distinct result, or "count"
+	// to count them. Only for FormatText, FormatJSON, and FormatXML.
+	Group string
+	// Quiet writes nothing. Streamed writes only the Summary, and any groups, as the
+	// output was Streamed to Log as it arrived.
+	Quiet    bool
+	Streamed bool
+
+	// Log is where the output goes. If nil, it's discarded.
+	Log   *log.Logger
+	Error *log.Logger
+
+	groups OutputGroups
+	csv    *csv.Writer
+	runID  string
+}
+
+// NewOutput returns an Output of the format, to the loggers
+func NewOutput(format string, l, e *log.Logger) *Output {
+	return &Output{
+		Format: format,
+		Log:    l,
+		Error:  e,
+	}
+}
+
+// Check returns an error if the Output can't be written
+func (o *Output) Check() error {
+	switch o.Format {
+	case FormatText, FormatJSON, FormatXML, FormatJSONL, FormatCSV:
+	case FormatTemplate:
+		if o.Template == nil {
+			return fmt.Errorf("format %s requires a template", o.Format)
+		}
+	default:
+		return fmt.Errorf(`format must be one of "text", "json", "xml", "jsonl", "csv", or "template"`)
+	}
+
+	if o.Group != "" && o.Group != "hosts" && o.Group != "count" {
+		return fmt.Errorf(`group must be one of "hosts" or "count"`)
+	} else if o.Group != "" && o.Format != FormatText && o.Format != FormatJSON && o.Format != FormatXML {
+		return fmt.Errorf("group can't be used with format %s", o.Format)
+	}
+	return nil
+}
+
+// Open writes what goes before the first record: The csv header
+func (o *Output) Open(state *RunState) error {
+	o.runID = state.ID
+	if o.Log == nil {
+		o.Log = log.New(ioutil.Discard, "", 0)
+	}
+
+	// Records are one per line, so the header only goes out once
+	o.csv = csv.NewWriter(o.Log.Writer())
+	if o.Format == FormatCSV && !o.Quiet && !o.Streamed {
+		o.csv.Write(RecordFields)
+		o.csv.Flush()
+	}
+	return nil
+}
+
+// Add writes the CommandReturns of the Result, or keeps them for later
+func (o *Output) Add(res Result) error {
+	if o.Group != "" {
+		o.groups.Add(res)
+	}
+	if o.Template != nil {
+		o.Template.Add(res)
+	}
+
+	for i := range res.CommandReturns {
+		c := &res.CommandReturns[i]
+		if o.Quiet || c.Quiet || o.Group != "" || o.Streamed {
+			// Shh, grouped for later, or streamed already
+			continue
+		}
+
+		switch o.Format {
+		case FormatJSONL:
+			rec := c.Record(o.runID)
+			o.Log.Println(string(rec.ToJSON()))
+		case FormatCSV:
+			rec := c.Record(o.runID)
+			o.csv.Write(rec.ToCSV())
+			o.csv.Flush()
+		case FormatTemplate:
+			// The whole run is output at the end
+		case FormatXML:
+			o.Log.Println(string(c.ToXML()))
+		case FormatJSON:
+			o.Log.Println(string(c.ToJSON(false)))
+		default:
+			o.Log.Println(c.ToText())
+		}
+	}
+	return nil
+}
+
+// Close writes any groups, or template, and the Summary
+func (o *Output) Close(state *RunState, summary *Summary) (err error) {
+	if o.Quiet {
+		return nil
+	}
+
+	if o.Group != "" {
+		switch o.Format {
+		case FormatXML:
+			o.Log.Println(string(o.groups.ToXML()))
+		case FormatJSON:
+			o.Log.Println(string(o.groups.ToJSON(false)))
+		default:
+			o.Log.Print(o.groups.ToText(o.Group == "count"))
+		}
+	}
+
+	if o.Template != nil && !o.Streamed {
+		if err = o.Template.Execute(o.Log.Writer(), state, summary); err != nil {
+			err = fmt.Errorf("executing template: %s", err)
+		}
+	}
+
+	switch o.Format {
+	case FormatJSONL, FormatCSV, FormatTemplate:
+		// Keep the log nothing but records, or the template
+		logf(o.Error, "%s", summary.ToText())
+	case FormatXML:
+		o.Log.Println(string(summary.ToXML()))
+	case FormatJSON:
+		o.Log.Println(string(summary.ToJSON(false)))
+	default:
+		o.Log.Print(summary.ToText())
+	}
+	return err
+}
+
+// OutDir is a Sink that writes each host's Result into a folder of Dir. See WriteOutDir.
+type OutDir struct {
+	Dir string
+}
+
+// Open does nothing, as folders are made as they're needed
+func (d *OutDir) Open(state *RunState) error {
+	return nil
+}
+
+// Add writes the Result into Dir
+func (d *OutDir) Add(res Result) error {
+	if err := WriteOutDir(d.Dir, res); err != nil {
+		return fmt.Errorf("writing results for %s to %s: %s", res.Host.Name, d.Dir, err)
+	}
+	return nil
+}
+
+// Close does nothing, as every Result is already written
+func (d *OutDir) Close(state *RunState, summary *Summary) error {
+	return nil
+}
+
+// JUnitFile is a Sink that writes a JUnitReport of the Run to Filename once it's over
+type JUnitFile struct {
+	Filename string
+	Report   *JUnitReport
+}
+
+// NewJUnitFile returns a JUnitFile for the named run: The workflow, or the command
+func NewJUnitFile(filename, name string) *JUnitFile {
+	return &JUnitFile{
+		Filename: filename,
+		Report:   NewJUnitReport(name),
+	}
+}
+
+// Open does nothing, as the Report is only written once the Run is over
+func (j *JUnitFile) Open(state *RunState) error {
+	return nil
+}
+
+// Add adds the Result to the Report
+func (j *JUnitFile) Add(res Result) error {
+	j.Report.Add(res)
+	return nil
+}
+
+// Close writes the Report to Filename
+func (j *JUnitFile) Close(state *RunState, summary *Summary) error {
+	if err := ioutil.WriteFile(j.Filename, j.Report.ToXML(), 0644); err != nil {
+		return fmt.Errorf("writing JUnit report: %s", err)
+	}
+	return nil
+}
+
+// HTMLFile is a Sink that writes an HTMLReport of the Run to Filename once it's over
+type HTMLFile struct {
+	Filename string
+	Report   *HTMLReport
+}
+
+// NewHTMLFile returns an HTMLFile for the hosts, chosen by filter
+func NewHTMLFile(filename, filter string, hosts []Host) *HTMLFile {
+	return &HTMLFile{
+		Filename: filename,
+		Report:   NewHTMLReport(filter, hosts),
+	}
+}
+
+// Open does nothing, as the Report is only written once the Run is over
+func (h *HTMLFile) Open(state *RunState) error {
+	return nil
+}
+
+// Add adds the Result to the Report
+func (h *HTMLFile) Add(res Result) error {
+	h.Report.Add(res)
+	return nil
+}
+
+// Close writes the Report to Filename
+func (h *HTMLFile) Close(state *RunState, summary *Summary) error {
+	var page bytes.Buffer
+	if err := h.Report.Write(&page, state, summary); err != nil {
+		return fmt.Errorf("generating HTML report: %s", err)
+	} else if err = ioutil.WriteFile(h.Filename, page.Bytes(), 0644); err != nil {
+		return fmt.Errorf("writing HTML report: %s", err)
+	}
+	return nil
+}
+
+// StateFile is a Sink that Saves the RunState to Filename once the Run is over, for
+// retrying the hosts that didn't succeed. See Runner.Retry.
+type StateFile struct {
+	Filename string
+}
+
+// Open does nothing, as the RunState is only Saved once it's finished
+func (s *StateFile) Open(state *RunState) error {
+	return nil
+}
+
+// Add does nothing, as the RunState is only Saved once it's finished
+func (s *StateFile) Add(res Result) error {
+	return nil
+}
+
+// Close Saves the RunState
+func (s *StateFile) Close(state *RunState, summary *Summary) error {
+	if err := state.Save(s.Filename); err != nil {
+		return fmt.Errorf("saving state file: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package deck
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testResults returns Results of two hosts: web01 succeeded, and web02 failed
+func testResults() []Result {
+	hosts := []Host{{Name: "web01", Address: "10.0.0.1"}, {Name: "web02", Address: "10.0.0.2"}}
+
+	ok := CommandReturn{HostObj: hosts[0], Hostname: "web01", Command: "uptime"}
+	ok.Stdout.WriteString("up\n")
+	bad := CommandReturn{HostObj: hosts[1], Hostname: "web02", Command: "uptime", Error: fmt.Errorf("exit 1"), ErrorType: ErrorExit, ExitCode: 1}
+
+	return []Result{
+		{Host: hosts[0], Status: StatusSucceeded, CommandReturns: []CommandReturn{ok}},
+		{Host: hosts[1], Status: StatusFailed, CommandReturns: []CommandReturn{bad}},
+	}
+}
+
+// runSink runs the Results through the Sink, as a Run would
+func runSink(t *testing.T, sink Sink, results []Result) {
+	state := &RunState{ID: "run1", Command: "uptime"}
+	summary := &Summary{}
+
+	if err := sink.Open(state); err != nil {
+		t.Fatalf("Unexpected error opening: %s\n", err)
+	}
+	for _, res := range results {
+		state.Add(res.Host, res.Status, res.Step)
+		summary.Add(res.Host.ID(), res.Status)
+		if err := sink.Add(res); err != nil {
+			t.Fatalf("Unexpected error adding: %s\n", err)
+		}
+	}
+	if err := sink.Close(state, summary); err != nil {
+		t.Fatalf("Unexpected error closing: %s\n", err)
+	}
+}
+
+func TestOutput_Check(t *testing.T) {
+	tmpl, err := ParseTemplate("t", "{{.ID}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, o := range map[string]*Output{
+		"bad format":     {Format: "yaml"},
+		"no template":    {Format: FormatTemplate},
+		"bad group":      {Format: FormatText, Group: "sum"},
+		"group of lines": {Format: FormatJSONL, Group: "hosts"},
+	} {
+		if err := o.Check(); err == nil {
+			t.Errorf("Expected error for %s, got nil\n", name)
+		}
+	}
+
+	for name, o := range map[string]*Output{
+		"text":     {Format: FormatText},
+		"template": {Format: FormatTemplate, Template: tmpl},
+		"group":    {Format: FormatJSON, Group: "count"},
+	} {
+		if err := o.Check(); err != nil {
+			t.Errorf("Expected valid Output for %s, got: %s\n", name, err)
+		}
+	}
+}
+
+func TestOutput(t *testing.T) {
+	tmpl, err := ParseTemplate("t", "{{range .Results}}{{.ID}}={{.Status}} {{end}}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, test := range map[string]struct {
+		o         *Output
+		want, not []string
+		summary   bool
+	}{
+		"text":     {o: &Output{Format: FormatText}, want: []string{"up", "EXIT: 1", "SUMMARY:"}},
+		"csv":      {o: &Output{Format: FormatCSV}, want: []string{strings.Join(RecordFields, ","), "run1,"}, not: []string{"SUMMARY:"}, summary: true},
+		"template": {o: &Output{Format: FormatTemplate, Template: tmpl}, want: []string{"web01/10.0.0.1=succeeded web02/10.0.0.2=failed"}, not: []string{"SUMMARY:"}, summary: true},
+		"group":    {o: &Output{Format: FormatText, Group: "count"}, want: []string{"1 hosts", "EXIT: 1", "SUMMARY:"}},
+		"streamed": {o: &Output{Format: FormatText, Streamed: true}, want: []string{"SUMMARY:"}, not: []string{"up\n"}},
+		"quiet":    {o: &Output{Format: FormatText, Quiet: true}, not: []string{"up", "SUMMARY:"}},
+	} {
+		var out, errs bytes.Buffer
+		test.o.Log = log.New(&out, "", 0)
+		test.o.Error = log.New(&errs, "", 0)
+		runSink(t, test.o, testResults())
+
+		for _, want := range test.want {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s: Expected '%s' in the output, got:\n%s\n", name, want, out.String())
+			}
+		}
+		for _, not := range test.not {
+			if strings.Contains(out.String(), not) {
+				t.Errorf("%s: Expected no '%s' in the output, got:\n%s\n", name, not, out.String())
+			}
+		}
+		if test.summary && !strings.Contains(errs.String(), "SUMMARY:") {
+			t.Errorf("%s: Expected the summary in the errors, got:\n%s\n", name, errs.String())
+		}
+	}
+}
+
+func TestOutput_NoLoggers(t *testing.T) {
+	for _, format := range []string{FormatText, FormatJSONL, FormatCSV} {
+		runSink(t, &Output{Format: format}, testResults())
+	}
+}
+
+func TestSinks_Files(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	results := testResults()
+	for _, sink := range []Sink{
+		&OutDir{Dir: filepath.Join(dir, "out")},
+		NewJUnitFile(filepath.Join(dir, "junit.xml"), "uptime"),
+		NewHTMLFile(filepath.Join(dir, "report.html"), "", []Host{results[0].Host, results[1].Host}),
+		&StateFile{Filename: filepath.Join(dir, "state", "state.json")},
+	} {
+		runSink(t, sink, results)
+	}
+
+	for _, f := range []string{"out/web01_10.0.0.1/meta.json", "out/web02_10.0.0.2/1.stdout", "junit.xml", "report.html"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf("Expected %s to be written: %s\n", f, err)
+		}
+	}
+
+	state, err := LoadRunState(filepath.Join(dir, "state", "state.json"))
+	if err != nil {
+		t.Fatalf("Expected the state to be saved: %s\n", err)
+	}
+	if failed := state.Failed(); len(failed) != 1 {
+		t.Errorf("Expected web02 to have failed, got %v\n", failed)
+	}
+}
@@ -0,0 +1,233 @@
+//go:build !windows && !plan9
+
+package deck
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is a tiny SSH server that executes "exec" requests via the local /bin/sh,
+// and forwards "direct-tcpip" channels, so it may be a jump host
+type testSSHServer struct {
+	Host  Host
+	Conns int32
+	// HostKeys are the server's host keys: ECDSA, and ed25519
+	HostKeys []ssh.PublicKey
+	// MaxSessions, if set, is how many sessions may be open on a connection at once,
+	// like sshd's
+	MaxSessions int32
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+	wg       sync.WaitGroup
+	conns    map[ssh.Conn]bool
+	lock     sync.Mutex
+}
+
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testSSHServer{
+		config: &ssh.ServerConfig{NoClientAuth: true},
+		conns:  make(map[ssh.Conn]bool),
+	}
+	for _, priv := range []interface{}{ecKey, edKey} {
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.config.AddHostKey(signer)
+		s.HostKeys = append(s.HostKeys, signer.PublicKey())
+	}
+
+	if s.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	addr := s.listener.Addr().(*net.TCPAddr)
+	s.Host = Host{Name: "testserver", Address: "127.0.0.1", Port: addr.Port}
+
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Command returns a Command for this server
+func (s *testSSHServer) Command(cmd string) Command {
+	return Command{
+		Cmd:  cmd,
+		Host: s.Host,
+		SSHConfig: &ssh.ClientConfig{
+			User:            "test",
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		},
+	}
+}
+
+// Drop closes the server's side of every connection, like a restarted sshd would
+func (s *testSSHServer) Drop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *testSSHServer) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *testSSHServer) serve() {
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			conn, chans, reqs, err := ssh.NewServerConn(nc, s.config)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.Conns, 1)
+			s.lock.Lock()
+			s.conns[conn] = true
+			s.lock.Unlock()
+			defer func() {
+				s.lock.Lock()
+				delete(s.conns, conn)
+				s.lock.Unlock()
+				conn.Close()
+			}()
+			go ssh.DiscardRequests(reqs)
+
+			var sessions int32
+			for nch := range chans {
+				if nch.ChannelType() == "direct-tcpip" {
+					go s.forward(nch)
+					continue
+				} else if nch.ChannelType() != "session" {
+					nch.Reject(ssh.UnknownChannelType, "sessions and forwarding only")
+					continue
+				}
+				if s.MaxSessions > 0 && atomic.AddInt32(&sessions, 1) > s.MaxSessions {
+					atomic.AddInt32(&sessions, -1)
+					nch.Reject(ssh.ResourceShortage, "too many sessions")
+					continue
+				}
+				ch, creqs, err := nch.Accept()
+				if err != nil {
+					continue
+				}
+				go func() {
+					s.session(ch, creqs)
+					if s.MaxSessions > 0 {
+						atomic.AddInt32(&sessions, -1)
+					}
+				}()
+			}
+		}()
+	}
+}
+
+// forward connects the "direct-tcpip" channel to the address it asks for
+func (s *testSSHServer) forward(nch ssh.NewChannel) {
+	var req struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(nch.ExtraData(), &req); err != nil {
+		nch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(req.Host, strconv.Itoa(int(req.Port))))
+	if err != nil {
+		nch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nch.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	go func() {
+		io.Copy(ch, conn)
+		ch.CloseWrite()
+	}()
+	io.Copy(conn, ch)
+	conn.Close()
+	ch.Close()
+}
+
+func (s *testSSHServer) session(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+
+	var cmd *exec.Cmd
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			req.Reply(true, nil)
+		case "signal":
+			if cmd != nil && cmd.Process != nil {
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+			}
+		case "exec":
+			l := binary.BigEndian.Uint32(req.Payload)
+			cmd = exec.Command("/bin/sh", "-c", string(req.Payload[4:4+l]))
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+			cmd.Stdin = ch
+			cmd.Stdout = ch
+			cmd.Stderr = ch.Stderr()
+
+			// Started here, so a signal never finds it half-started
+			if err := cmd.Start(); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+
+			go func(cmd *exec.Cmd) {
+				status := 0
+				if err := cmd.Wait(); err != nil {
+					if ee, ok := err.(*exec.ExitError); ok {
+						status = ee.ExitCode()
+					} else {
+						status = 255
+					}
+				}
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+				ch.Close()
+			}(cmd)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package deck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HostState is how far a host got in a run
+type HostState struct {
+	Host string
+	// Address is the address the host was connected to, as hosts may share a Name
+	Address string `json:",omitempty"`
+	Status  string
+	// Step is the workflow command the host reached, starting at 1. 0 is none.
+	Step int `json:",omitempty"`
+}
+
+// newHostState returns the HostState of the Host
+func newHostState(h Host, status string, step int) HostState {
+	return HostState{Host: h.Name, Address: h.ConnectAddress(), Status: status, Step: step}
+}
+
+// Key identifies the host: its name and address, as Host.ID
+func (h HostState) Key() string {
+	return hostID(h.Host, h.Address)
+}
+
+// RunState is the machine-readable state of a run, written at the end of every run,
+// so that failed hosts may be retried
+type RunState struct {
+	// ID identifies the run, as RunID in Records
+	ID       string `json:",omitempty"`
+	Started  time.Time
+	Ended    time.Time
+	Workflow string `json:",omitempty"`
+	Command  string `json:",omitempty"`
+	Hosts    []HostState
+
+	lock sync.Mutex
+}
+
+// newRunID returns a new RunState ID, for a run started at the time
+func newRunID(started time.Time) string {
+	return started.UTC().Format("20060102T150405Z") + "-" + randString(6)
+}
+
+// Add records the state of the Host
+func (s *RunState) Add(h Host, status string, step int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.Hosts = append(s.Hosts, newHostState(h, status, step))
+}
+
+// Failed returns the state of every host that didn't succeed, by Key
+func (s *RunState) Failed() map[string]HostState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	failed := make(map[string]HostState)
+	for _, h := range s.Hosts {
+		if h.Status != StatusSucceeded {
+			failed[h.Key()] = h
+		}
+	}
+	return failed
+}
+
+// Save writes the RunState to the file, replacing it
+func (s *RunState) Save(filename string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sort.Slice(s.Hosts, func(i, j int) bool {
+		if s.Hosts[i].Host != s.Hosts[j].Host {
+			return s.Hosts[i].Host < s.Hosts[j].Host
+		}
+		return s.Hosts[i].Address < s.Hosts[j].Address
+	})
+
+	j, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+
+	// Write it next to where it's going, and move it into place, so a crash
+	// never leaves a half-written state
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".state")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(append(j, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// LoadRunState reads a RunState from the file
+func LoadRunState(filename string) (*RunState, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var s RunState
+	if err = json.Unmarshal(buf, &s); err != nil {
+		return nil, fmt.Errorf("error parsing state file '%s': %s", filename, err)
+	}
+	return &s, nil
+}
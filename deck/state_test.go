@@ -0,0 +1,111 @@
+package deck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunState_SaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "sub", "state.json")
+
+	s := &RunState{Workflow: "updateall"}
+	web2 := Host{Name: "web2", Address: "10.0.0.2"}
+	web3 := Host{Name: "web3"}
+	s.Add(web2, StatusFailed, 3)
+	s.Add(Host{Name: "web2", Address: "10.0.0.3"}, StatusSucceeded, 5)
+	s.Add(Host{Name: "web1"}, StatusSucceeded, 5)
+	s.Add(web3, StatusSkipped, 0)
+
+	if err := s.Save(filename); err != nil {
+		t.Fatalf("Unexpected error saving: %s\n", err)
+	}
+
+	l, err := LoadRunState(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error loading: %s\n", err)
+	}
+	if l.Workflow != "updateall" || len(l.Hosts) != 4 || l.Hosts[0].Host != "web1" || l.Hosts[1].Address != "10.0.0.2:22" {
+		t.Errorf("Unexpected state loaded: %+v\n", l)
+	}
+
+	failed := l.Failed()
+	if len(failed) != 2 {
+		t.Fatalf("Expected 2 failed hosts, got %d\n", len(failed))
+	}
+	key := newHostState(web2, "", 0).Key()
+	if failed[key].Step != 3 || failed[key].Status != StatusFailed {
+		t.Errorf("Unexpected web2 state: %+v\n", failed[key])
+	}
+	if _, ok := failed[newHostState(web3, "", 0).Key()]; !ok {
+		t.Error("Expected skipped host to need retrying")
+	}
+
+	// No leftovers
+	entries, _ := os.ReadDir(filepath.Dir(filename))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the state file, got %d files\n", len(entries))
+	}
+}
+
+func TestRunState_LoadBad(t *testing.T) {
+	if _, err := LoadRunState(filepath.Join(t.TempDir(), "nope.json")); err == nil {
+		t.Error("Expected error loading missing state file")
+	}
+
+	filename := filepath.Join(t.TempDir(), "bad.json")
+	os.WriteFile(filename, []byte("{nope"), 0600)
+	if _, err := LoadRunState(filename); err == nil {
+		t.Error("Expected error loading bad state file")
+	}
+}
+
+func TestRunState_RetryChained(t *testing.T) {
+	conf, err := LoadConfigs("testconfigs/")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+	workflows := len(conf.Workflows)
+	list := "restart-tomcat,updateall"
+	if err := conf.ChainWorkflows(list); err != nil {
+		t.Fatalf("Unexpected error chaining: %s\n", err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "state.json")
+	s := &RunState{Workflow: list}
+	s.Add(Host{Name: "web1"}, StatusFailed, 2)
+	if err := s.Save(filename); err != nil {
+		t.Fatalf("Unexpected error saving: %s\n", err)
+	}
+
+	// Retrying, in a fresh run without --workflow
+	conf, _ = LoadConfigs("testconfigs/")
+	l, err := LoadRunState(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error loading: %s\n", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := conf.ChainWorkflows(l.Workflow); err != nil {
+			t.Fatalf("Unexpected error chaining: %s\n", err)
+		}
+	}
+	if len(conf.Workflows) != workflows+1 {
+		t.Errorf("Expected the chained workflow added once, got %d workflows\n", len(conf.Workflows))
+	}
+	wfi := conf.WorkflowIndex(l.Workflow)
+	if wfi < 0 {
+		t.Fatalf("Expected workflow '%s' to exist\n", l.Workflow)
+	}
+	want := len(conf.Workflows[conf.WorkflowIndex("restart-tomcat")].Commands) + len(conf.Workflows[conf.WorkflowIndex("updateall")].Commands)
+	if got := len(conf.Workflows[wfi].Commands); got != want {
+		t.Errorf("Expected %d commands, got %d\n", want, got)
+	}
+	if err := conf.Check(); err != nil {
+		t.Errorf("Unexpected error checking: %s\n", err)
+	}
+
+	if err := conf.ChainWorkflows("updateall,nope"); err == nil {
+		t.Error("Expected error chaining a missing workflow")
+	}
+}
@@ -0,0 +1,127 @@
+package deck
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+	"sync"
+)
+
+// hostColors are the ANSI colors host prefixes may be, skipping black and white
+var hostColors = []string{"31", "32", "33", "34", "35", "36", "91", "92", "93", "94", "95", "96"}
+
+// OutputLine is a line of output from a command, as it arrives
+type OutputLine struct {
+	Host    Host
+	Command string
+	Step    int
+	Stderr  bool
+	Line    string
+}
+
+// lineWriter calls fn with every complete line written to it, without the line
+// ending, and with whatever is left over when flushed
+type lineWriter struct {
+	fn   func(line string)
+	buf  []byte
+	lock sync.Mutex
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush sends along any partial line
+func (w *lineWriter) Flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.buf) > 0 {
+		w.fn(strings.TrimSuffix(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
+
+// LinePrinter prints OutputLines as they arrive, prefixed by the name of the host,
+// like dsh or pssh -i, or by its ID if other hosts share its name. Stdout lines go to
+// Stdout, and Stderr lines to Stderr.
+type LinePrinter struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	// Color colors each host's prefix, the same color every time
+	Color bool
+	// Width pads the host names, so the lines line up
+	Width int
+
+	// shared are the names more than one of the hosts have
+	shared map[string]bool
+	lock   sync.Mutex
+}
+
+// NewLinePrinter returns a LinePrinter, wide enough for the names of the hosts, telling
+// apart those that share a name
+func NewLinePrinter(stdout, stderr io.Writer, color bool, hosts []Host) *LinePrinter {
+	p := &LinePrinter{Stdout: stdout, Stderr: stderr, Color: color, shared: make(map[string]bool)}
+
+	ids := make(map[string]string)
+	for i := range hosts {
+		h := &hosts[i]
+		if id, ok := ids[h.Name]; ok && id != h.ID() {
+			p.shared[h.Name] = true
+		}
+		ids[h.Name] = h.ID()
+	}
+	for i := range hosts {
+		if name := p.name(&hosts[i]); len(name) > p.Width {
+			p.Width = len(name)
+		}
+	}
+	return p
+}
+
+// Print prints the OutputLine. It is safe to call from many goroutines at once.
+func (p *LinePrinter) Print(l OutputLine) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	out := p.Stdout
+	if l.Stderr {
+		out = p.Stderr
+	}
+	fmt.Fprintf(out, "%s %s\n", p.prefix(p.name(&l.Host)), l.Line)
+}
+
+// name returns what lines from the host are prefixed with: its name, or its ID if
+// other hosts share its name
+func (p *LinePrinter) name(h *Host) string {
+	if p.shared[h.Name] {
+		return h.ID()
+	}
+	return h.Name
+}
+
+// prefix returns the prefix for lines from the named host
+func (p *LinePrinter) prefix(name string) string {
+	prefix := fmt.Sprintf("%-*s", p.Width+1, name+":")
+	if !p.Color {
+		return prefix
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return "\x1b[" + hostColors[h.Sum32()%uint32(len(hostColors))] + "m" + prefix + "\x1b[0m"
+}
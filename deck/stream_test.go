@@ -0,0 +1,62 @@
+package deck
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := &lineWriter{fn: func(line string) {
+		lines = append(lines, line)
+	}}
+
+	w.Write([]byte("one\ntw"))
+	w.Write([]byte("o\r\nthree\n\nfou"))
+	if len(lines) != 4 || lines[0] != "one" || lines[1] != "two" || lines[2] != "three" || lines[3] != "" {
+		t.Errorf("Expected [one two three ''], got %q\n", lines)
+	}
+
+	w.Flush()
+	w.Flush()
+	if len(lines) != 5 || lines[4] != "fou" {
+		t.Errorf("Expected the partial line once when flushed, got %q\n", lines)
+	}
+}
+
+func TestLinePrinter(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	p := NewLinePrinter(&stdout, &stderr, false, []Host{{Name: "a"}, {Name: "longer"}})
+
+	p.Print(OutputLine{Host: Host{Name: "a"}, Line: "hello"})
+	p.Print(OutputLine{Host: Host{Name: "longer"}, Line: "hello"})
+	p.Print(OutputLine{Host: Host{Name: "a"}, Line: "oops", Stderr: true})
+
+	if stdout.String() != "a:      hello\nlonger: hello\n" {
+		t.Errorf("Expected aligned prefixes, got %q\n", stdout.String())
+	}
+	if stderr.String() != "a:      oops\n" {
+		t.Errorf("Expected stderr line, got %q\n", stderr.String())
+	}
+
+	p.Color = true
+	if c := p.prefix("a"); !strings.HasPrefix(c, "\x1b[") || !strings.Contains(c, "a:") || c != p.prefix("a") {
+		t.Errorf("Expected the same colored prefix every time, got %q\n", c)
+	}
+}
+
+func TestLinePrinter_SharedNames(t *testing.T) {
+	var stdout bytes.Buffer
+	hosts := []Host{{Name: "web01", Address: "10.0.0.1"}, {Name: "web01", Address: "10.0.0.2"}, {Name: "db"}}
+	p := NewLinePrinter(&stdout, &stdout, false, hosts)
+
+	for _, h := range hosts {
+		p.Print(OutputLine{Host: h, Line: "hello"})
+	}
+
+	expected := "web01/10.0.0.1: hello\nweb01/10.0.0.2: hello\ndb:             hello\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected %q, got %q\n", expected, stdout.String())
+	}
+}
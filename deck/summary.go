@@ -0,0 +1,189 @@
+package deck
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Host statuses, at the end of a run
+const (
+	StatusSucceeded   = "succeeded"
+	StatusFailed      = "failed"
+	StatusUnreachable = "unreachable"
+	StatusTimedOut    = "timedout"
+	StatusSkipped     = "skipped"
+)
+
+// Exit codes. ExitFatal is for problems before anything runs, otherwise the exit
+// code is the sum of the other codes for every status any host ended up in.
+const (
+	ExitOK          = 0
+	ExitFatal       = 1
+	ExitFailed      = 2
+	ExitUnreachable = 4
+	ExitTimedOut    = 8
+	ExitSkipped     = 16
+)
+
+// SlowestHosts is how many of the slowest hosts a Summary lists
+const SlowestHosts = 5
+
+// HostDuration is how long a host took
+type HostDuration struct {
+	Host    string  `xml:",chardata"`
+	Seconds float64 `xml:"seconds,attr"`
+}
+
+// Summary is how every host fared, at the end of a run
+type Summary struct {
+	XMLName     xml.Name `json:"-" xml:"Summary"`
+	Succeeded   []string `xml:"Succeeded>Host"`
+	Failed      []string `xml:"Failed>Host"`
+	Unreachable []string `xml:"Unreachable>Host"`
+	TimedOut    []string `xml:"TimedOut>Host"`
+	Skipped     []string `xml:"Skipped>Host"`
+	// Slowest are the SlowestHosts hosts that took longest, slowest first
+	Slowest  []HostDuration `xml:"Slowest>Host"`
+	ExitCode int
+
+	lock sync.Mutex
+}
+
+// Add records the status of the host
+func (s *Summary) Add(host, status string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch status {
+	case StatusSucceeded:
+		s.Succeeded = append(s.Succeeded, host)
+	case StatusFailed:
+		s.Failed = append(s.Failed, host)
+		s.ExitCode |= ExitFailed
+	case StatusUnreachable:
+		s.Unreachable = append(s.Unreachable, host)
+		s.ExitCode |= ExitUnreachable
+	case StatusTimedOut:
+		s.TimedOut = append(s.TimedOut, host)
+		s.ExitCode |= ExitTimedOut
+	case StatusSkipped:
+		s.Skipped = append(s.Skipped, host)
+		s.ExitCode |= ExitSkipped
+	}
+}
+
+// Took records how long the host took, keeping it if it is one of the slowest
+func (s *Summary) Took(host string, d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.Slowest = append(s.Slowest, HostDuration{Host: host, Seconds: d.Seconds()})
+	sort.SliceStable(s.Slowest, func(i, j int) bool {
+		return s.Slowest[i].Seconds > s.Slowest[j].Seconds
+	})
+	if len(s.Slowest) > SlowestHosts {
+		s.Slowest = s.Slowest[:SlowestHosts]
+	}
+}
+
+// Hosts returns the total number of hosts in the Summary
+func (s *Summary) Hosts() int {
+	return len(s.Succeeded) + len(s.Failed) + len(s.Unreachable) + len(s.TimedOut) + len(s.Skipped)
+}
+
+// sorted sorts all the host lists, for stable output, making sure none are nil
+func (s *Summary) sorted() {
+	for _, l := range []*[]string{&s.Succeeded, &s.Failed, &s.Unreachable, &s.TimedOut, &s.Skipped} {
+		if *l == nil {
+			*l = []string{}
+		}
+		sort.Strings(*l)
+	}
+	if s.Slowest == nil {
+		s.Slowest = []HostDuration{}
+	}
+}
+
+// ToText returns the Summary as text
+func (s *Summary) ToText() (out string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sorted()
+
+	out = fmt.Sprintf("SUMMARY: %d hosts: %d succeeded, %d failed, %d unreachable, %d timed out, %d skipped\n",
+		s.Hosts(), len(s.Succeeded), len(s.Failed), len(s.Unreachable), len(s.TimedOut), len(s.Skipped))
+	for _, l := range []struct {
+		name  string
+		hosts []string
+	}{{"FAILED", s.Failed}, {"UNREACHABLE", s.Unreachable}, {"TIMED OUT", s.TimedOut}, {"SKIPPED", s.Skipped}} {
+		if len(l.hosts) > 0 {
+			out = out + fmt.Sprintf("%s: %s\n", l.name, strings.Join(l.hosts, " "))
+		}
+	}
+	if len(s.Slowest) > 0 {
+		var slowest []string
+		for _, hd := range s.Slowest {
+			slowest = append(slowest, fmt.Sprintf("%s (%s)", hd.Host, fmtSeconds(hd.Seconds)))
+		}
+		out = out + fmt.Sprintf("SLOWEST: %s\n", strings.Join(slowest, " "))
+	}
+	return out + fmt.Sprintf("EXIT: %d\n", s.ExitCode)
+}
+
+// ToJSON returns the Summary as JSON
+func (s *Summary) ToJSON(pretty bool) (j []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sorted()
+
+	// A Summary is only strings and numbers, so it always marshals
+	if !pretty {
+		j, _ = json.Marshal(s)
+	} else {
+		j, _ = json.MarshalIndent(s, "", "\t")
+	}
+	return
+}
+
+// ToXML returns the Summary as XML
+func (s *Summary) ToXML() []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sorted()
+
+	x, _ := xml.Marshal(s)
+	return x
+}
+
+// Status returns how the command fared
+func (cr *CommandReturn) Status() string {
+	switch {
+	case cr.Error == nil:
+		return StatusSucceeded
+	case cr.TimedOut:
+		return StatusTimedOut
+	case cr.ErrorType == ErrorConnection:
+		return StatusUnreachable
+	}
+	return StatusFailed
+}
+
+// Status returns how the workflow fared: Succeeded if it completed, otherwise by
+// the last command that failed
+func (wr *WorkflowReturn) Status() string {
+	if wr.Completed {
+		return StatusSucceeded
+	}
+
+	for i := len(wr.CommandReturns) - 1; i >= 0; i-- {
+		if s := wr.CommandReturns[i].Status(); s != StatusSucceeded {
+			return s
+		}
+	}
+	return StatusFailed
+}
@@ -0,0 +1,121 @@
+package deck
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummary_ExitCode(t *testing.T) {
+	s := &Summary{}
+	s.Add("a", StatusSucceeded)
+	if s.ExitCode != ExitOK {
+		t.Errorf("Expected exit %d, got %d\n", ExitOK, s.ExitCode)
+	}
+
+	s.Add("b", StatusFailed)
+	s.Add("c", StatusFailed)
+	if s.ExitCode != ExitFailed {
+		t.Errorf("Expected exit %d, got %d\n", ExitFailed, s.ExitCode)
+	}
+
+	s.Add("d", StatusUnreachable)
+	s.Add("e", StatusTimedOut)
+	s.Add("f", StatusSkipped)
+	if e := ExitFailed + ExitUnreachable + ExitTimedOut + ExitSkipped; s.ExitCode != e {
+		t.Errorf("Expected exit %d, got %d\n", e, s.ExitCode)
+	}
+	if s.Hosts() != 6 {
+		t.Errorf("Expected 6 hosts, got %d\n", s.Hosts())
+	}
+}
+
+func TestSummary_Formats(t *testing.T) {
+	s := &Summary{}
+	s.Add("b", StatusSucceeded)
+	s.Add("a", StatusSucceeded)
+	s.Add("c", StatusUnreachable)
+
+	text := s.ToText()
+	if !strings.HasPrefix(text, "SUMMARY: 3 hosts: 2 succeeded, 0 failed, 1 unreachable, 0 timed out, 0 skipped\n") {
+		t.Errorf("Unexpected text summary: %s\n", text)
+	}
+	if !strings.Contains(text, "UNREACHABLE: c\n") || !strings.HasSuffix(text, "EXIT: 4\n") {
+		t.Errorf("Unexpected text summary: %s\n", text)
+	}
+
+	var j map[string]interface{}
+	if err := json.Unmarshal(s.ToJSON(false), &j); err != nil {
+		t.Fatalf("Bad JSON summary: %s\n", err)
+	}
+	if fmt.Sprint(j["Succeeded"]) != "[a b]" || j["ExitCode"].(float64) != ExitUnreachable {
+		t.Errorf("Unexpected JSON summary: %v\n", j)
+	}
+
+	var x struct {
+		Succeeded []string `xml:"Succeeded>Host"`
+		ExitCode  int
+	}
+	if err := xml.Unmarshal(s.ToXML(), &x); err != nil {
+		t.Fatalf("Bad XML summary: %s\n", err)
+	}
+	if len(x.Succeeded) != 2 || x.ExitCode != ExitUnreachable {
+		t.Errorf("Unexpected XML summary: %+v\n", x)
+	}
+}
+
+func TestSummary_Slowest(t *testing.T) {
+	s := &Summary{}
+	for i := 1; i <= SlowestHosts+2; i++ {
+		s.Took(fmt.Sprintf("h%d", i), time.Duration(i)*time.Second)
+	}
+
+	if len(s.Slowest) != SlowestHosts || s.Slowest[0].Host != "h7" || s.Slowest[0].Seconds != 7 {
+		t.Errorf("Expected the %d slowest, slowest first, got %+v\n", SlowestHosts, s.Slowest)
+	}
+	if text := s.ToText(); !strings.Contains(text, "SLOWEST: h7 (7s) h6 (6s) h5 (5s) h4 (4s) h3 (3s)\n") {
+		t.Errorf("Unexpected text summary: %s\n", text)
+	}
+
+	var x struct {
+		Slowest []HostDuration `xml:"Slowest>Host"`
+	}
+	if err := xml.Unmarshal(s.ToXML(), &x); err != nil {
+		t.Fatalf("Bad XML summary: %s\n", err)
+	}
+	if len(x.Slowest) != SlowestHosts || x.Slowest[4].Host != "h3" || x.Slowest[4].Seconds != 3 {
+		t.Errorf("Unexpected XML summary: %+v\n", x)
+	}
+}
+
+func TestSummary_Status(t *testing.T) {
+	crs := map[string]*CommandReturn{
+		StatusSucceeded:   {},
+		StatusFailed:      {Error: fmt.Errorf("exit 1"), ErrorType: ErrorExit},
+		StatusUnreachable: {Error: fmt.Errorf("no route"), ErrorType: ErrorConnection},
+		StatusTimedOut:    {Error: fmt.Errorf("timed out"), ErrorType: ErrorTimeout, TimedOut: true},
+	}
+	for status, cr := range crs {
+		if s := cr.Status(); s != status {
+			t.Errorf("Expected command status %s, got %s\n", status, s)
+		}
+	}
+
+	wr := WorkflowReturn{Completed: true}
+	if s := wr.Status(); s != StatusSucceeded {
+		t.Errorf("Expected completed workflow to succeed, got %s\n", s)
+	}
+
+	wr = WorkflowReturn{CommandReturns: []CommandReturn{{}, {Error: fmt.Errorf("timed out"), TimedOut: true}}}
+	if s := wr.Status(); s != StatusTimedOut {
+		t.Errorf("Expected workflow to time out, got %s\n", s)
+	}
+
+	wr = WorkflowReturn{}
+	if s := wr.Status(); s != StatusFailed {
+		t.Errorf("Expected empty incomplete workflow to fail, got %s\n", s)
+	}
+}
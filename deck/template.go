@@ -0,0 +1,135 @@
+package deck
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are the functions available to a Template, beyond text/template's own
+var templateFuncs = template.FuncMap{
+	"join":    strings.Join,
+	"lines":   func(s string) []string { return strings.Split(strings.TrimRight(s, "\n"), "\n") },
+	"trim":    strings.TrimSpace,
+	"replace": func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+}
+
+// TemplateData is what a Template is executed with: The run, and every host's Result
+type TemplateData struct {
+	RunID    string
+	Workflow string
+	Command  string
+	Started  time.Time
+	Ended    time.Time
+	// Results are every host's, in order by name
+	Results []TemplateResult
+	Summary *Summary
+}
+
+// TemplateResult is a host's Result, in TemplateData
+type TemplateResult struct {
+	Host Host
+	// ID identifies the Host, as in the Summary. See Host.ID.
+	ID     string
+	Status string
+	// Step is the workflow command the host reached, starting at 1. 0 is none, or not a workflow.
+	Step int
+	// Commands are every command executed on the host, as Records, except QUIET ones
+	Commands []Record
+}
+
+// Seconds returns how long all of the host's commands took
+func (tr *TemplateResult) Seconds() (secs float64) {
+	for _, rec := range tr.Commands {
+		secs += rec.Seconds
+	}
+	return
+}
+
+// Failed returns the command the host failed on, or nil if it didn't fail on one
+func (tr *TemplateResult) Failed() *Record {
+	for i := len(tr.Commands) - 1; i >= 0; i-- {
+		if tr.Commands[i].Status != StatusSucceeded {
+			return &tr.Commands[i]
+		}
+	}
+	return nil
+}
+
+// Template renders a run with a text/template, for the template format
+type Template struct {
+	tmpl    *template.Template
+	results []Result
+	lock    sync.Mutex
+}
+
+// ParseTemplate returns a Template of the text/template text
+func ParseTemplate(name, text string) (*Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// ParseTemplateFile returns a Template of the text/template in the file
+func ParseTemplateFile(filename string) (*Template, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplate(filepath.Base(filename), string(b))
+}
+
+// Add adds the Result, for when the Template is executed
+func (t *Template) Add(res Result) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.results = append(t.results, res)
+}
+
+// Execute writes the Template, executed with the RunState, Summary, and every Result
+// added, to w
+func (t *Template) Execute(w io.Writer, state *RunState, summary *Summary) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.tmpl.Execute(w, newTemplateData(state, summary, t.results))
+}
+
+// newTemplateData returns the TemplateData of the run
+func newTemplateData(state *RunState, summary *Summary, results []Result) TemplateData {
+	data := TemplateData{
+		RunID:    state.ID,
+		Workflow: state.Workflow,
+		Command:  state.Command,
+		Started:  state.Started,
+		Ended:    state.Ended,
+		Results:  []TemplateResult{},
+		Summary:  summary,
+	}
+
+	for _, res := range results {
+		tr := TemplateResult{Host: res.Host, ID: res.Host.ID(), Status: res.Status, Step: res.Step, Commands: []Record{}}
+		for i := range res.CommandReturns {
+			if res.CommandReturns[i].Quiet {
+				continue
+			}
+			tr.Commands = append(tr.Commands, res.CommandReturns[i].Record(state.ID))
+		}
+		data.Results = append(data.Results, tr)
+	}
+	sort.SliceStable(data.Results, func(i, j int) bool {
+		return data.Results[i].ID < data.Results[j].ID
+	})
+
+	return data
+}
@@ -0,0 +1,51 @@
+package deck
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate("test", `{{.RunID}} {{.Command}}
+{{range .Results}}| {{.Host.Name}} | {{.Status}} |{{range .Commands}} {{replace "|" "\\|" .Stdout | trim}}{{end}}
+{{end}}{{len .Summary.Failed}} failed
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing: %s\n", err)
+	}
+
+	cr := CommandReturn{HostObj: Host{Name: "web02"}, Command: "echo"}
+	cr.Stdout.WriteString(" a|b \n")
+	tmpl.Add(Result{Host: Host{Name: "web02"}, Status: StatusSucceeded, CommandReturns: []CommandReturn{cr}})
+	tmpl.Add(Result{Host: Host{Name: "web01"}, Status: StatusSkipped})
+
+	summary := &Summary{}
+	summary.Add("web02", StatusSucceeded)
+	summary.Add("web01", StatusSkipped)
+
+	var out bytes.Buffer
+	if err = tmpl.Execute(&out, &RunState{ID: "run1", Command: "echo"}, summary); err != nil {
+		t.Fatalf("Unexpected error executing: %s\n", err)
+	}
+
+	expected := "run1 echo\n| web01 | skipped |\n| web02 | succeeded | a\\|b\n0 failed\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s\n", expected, out.String())
+	}
+}
+
+func TestParseTemplateFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "bad.tmpl")
+	if err := ioutil.WriteFile(filename, []byte("{{range .Results}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTemplateFile(filename); err == nil {
+		t.Error("Expected error parsing broken template, got nil")
+	}
+
+	if _, err := ParseTemplateFile(filepath.Join(t.TempDir(), "nope.tmpl")); err == nil {
+		t.Error("Expected error for missing file, got nil")
+	}
+}
@@ -0,0 +1,323 @@
+package deck
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transferResult is the outcome of speaking the scp protocol to a remote scp
+type transferResult struct {
+	proto error
+	wait  error
+}
+
+// Put copies the local file to the remote path on the Command's Host, via scp. A remote
+// path ending in "/" is a directory, to put the file into. If mode is 0, the local file's
+// permissions are used, otherwise they are applied to the remote file. If the Command is
+// Sudo, the file is uploaded to a temporary location, and then moved into place via sudo.
+func (c *Command) Put(ctx context.Context, local, remote string, mode os.FileMode) (cr CommandReturn) {
+	cr = c.transferReturn(fmt.Sprintf("PUT %s %s", local, remote))
+	defer cr.ended()
+
+	f, err := os.Open(local)
+	if err != nil {
+		c.Runner.errorf("PUT to %s failed: %s\n", cr.Hostname, err)
+		cr.Error = err
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		cr.Error = err
+		return
+	} else if !stat.Mode().IsRegular() {
+		cr.Error = fmt.Errorf("PUT of non-regular file %s", local)
+		return
+	}
+
+	applyMode := mode != 0
+	if !applyMode {
+		mode = stat.Mode().Perm()
+	}
+
+	if strings.HasSuffix(remote, "/") {
+		remote += filepath.Base(local)
+	}
+
+	dest := remote
+	if c.Sudo {
+		dest = "/tmp/.all-" + randString(16)
+	}
+
+	if c.Runner.dryRun() {
+		return
+	}
+
+	c.scp(ctx, &cr, "scp -t "+shellQuote(dest), func(w io.Writer, r *bufio.Reader) error {
+		if err := scpAck(r); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "C%04o %d %s\n", mode, stat.Size(), path.Base(dest))
+		if err := scpAck(r); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, f); err != nil {
+			return err
+		}
+		w.Write([]byte{0})
+		return scpAck(r)
+	})
+	if cr.Error != nil {
+		c.Runner.errorf("PUT to %s failed: %s\n", cr.Hostname, cr.Error)
+		return
+	}
+
+	// Put it in its place
+	com := *c
+	if c.Sudo {
+		com.Cmd = fmt.Sprintf("install -m %04o %s %s; rc=$?; rm -f %s; exit $rc", mode, shellQuote(dest), shellQuote(remote), shellQuote(dest))
+	} else if applyMode {
+		com.Cmd = fmt.Sprintf("chmod %04o %s", mode, shellQuote(remote))
+	}
+	if com.Cmd != "" {
+		res := com.ExecContext(ctx)
+		cr.Connect += res.Connect
+		cr.Session += res.Session
+		cr.Exec += res.Exec
+		cr.Stdout.Write(res.Stdout.Bytes())
+		cr.Stderr.Write(res.Stderr.Bytes())
+		if res.Error != nil {
+			cr.Error = res.Error
+			cr.ErrorType = res.ErrorType
+			cr.ExitCode = res.ExitCode
+			cr.ExitSignal = res.ExitSignal
+			cr.TimedOut = res.TimedOut
+			return
+		}
+	}
+
+	fmt.Fprintf(&cr.Stdout, "Copied %d bytes to %s (%04o)\n", stat.Size(), remote, mode)
+	return
+}
+
+// Get copies the remote file from the Command's Host into localDir/<host name>_<address>/, via scp,
+// preserving its permissions. If the Command is Sudo, the remote file is read via sudo.
+func (c *Command) Get(ctx context.Context, remote, localDir string) (cr CommandReturn) {
+	cr = c.transferReturn(fmt.Sprintf("GET %s %s", remote, localDir))
+	defer cr.ended()
+
+	hname, err := hostDirName(c.Host)
+	if err != nil {
+		c.Runner.errorf("GET from %s failed: %s\n", cr.Hostname, err)
+		cr.Error = err
+		return
+	}
+	dir := filepath.Join(localDir, hname)
+
+	if c.Runner.dryRun() {
+		return
+	}
+
+	cmd := "scp -f " + shellQuote(remote)
+	if c.Sudo {
+		// No terminal for this, so sudo must not need one
+		cmd = "sudo -n " + cmd
+	}
+
+	var (
+		local string
+		size  int64
+	)
+	c.scp(ctx, &cr, cmd, func(w io.Writer, r *bufio.Reader) error {
+		w.Write([]byte{0})
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		} else if line[0] == 1 || line[0] == 2 {
+			return fmt.Errorf("%s", strings.TrimSpace(line[1:]))
+		}
+
+		var (
+			mode os.FileMode
+			name string
+		)
+		if mode, size, name, err = parseScpHeader(line); err != nil {
+			return err
+		}
+
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		local = filepath.Join(dir, name)
+
+		f, err := os.OpenFile(local, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		w.Write([]byte{0})
+
+		_, err = io.CopyN(f, r, size)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if err = scpAck(r); err != nil {
+			return err
+		}
+		w.Write([]byte{0})
+
+		// Regardless of umask
+		return os.Chmod(local, mode)
+	})
+	if cr.Error != nil {
+		c.Runner.errorf("GET from %s failed: %s\n", cr.Hostname, cr.Error)
+		return
+	}
+
+	fmt.Fprintf(&cr.Stdout, "Copied %d bytes to %s\n", size, local)
+	return
+}
+
+// transferReturn returns a CommandReturn for a transfer
+func (c *Command) transferReturn(command string) CommandReturn {
+	cr := CommandReturn{
+		HostObj:  c.Host,
+		Hostname: c.Host.Address,
+		Command:  command,
+		Step:     c.Step,
+		Quiet:    c.Quiet,
+		Start:    time.Now(),
+		runner:   c.Runner,
+	}
+	if cr.Hostname == "" {
+		cr.Hostname = c.Host.Name
+	}
+	c.Runner.debugf("Executing transfer '%s'\n", command)
+	return cr
+}
+
+// scp runs the remote scp command on a new session, with fn speaking the scp protocol
+// to it. Failures are recorded in the CommandReturn.
+func (c *Command) scp(ctx context.Context, cr *CommandReturn, cmd string, fn func(w io.Writer, r *bufio.Reader) error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	session, done, err := c.session(ctx, cr)
+	if err != nil {
+		c.sessionFailed(ctx, cr, err)
+		return
+	}
+	defer done()
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		c.runFailed(cr, err)
+		return
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		c.runFailed(cr, err)
+		return
+	}
+	var stderr safeBuffer
+	session.Stderr = &stderr
+	defer stderr.WriteTo(&cr.Stderr)
+
+	started := time.Now()
+	defer func() {
+		cr.Exec = time.Since(started)
+	}()
+	if err = session.Start(cmd); err != nil {
+		c.runFailed(cr, err)
+		return
+	}
+
+	results := make(chan transferResult, 1)
+	go func() {
+		var res transferResult
+		res.proto = fn(w, bufio.NewReader(r))
+		w.Close()
+
+		// If we bailed early, there may be more coming
+		go io.Copy(ioutil.Discard, r)
+
+		res.wait = session.Wait()
+		results <- res
+	}()
+
+	select {
+	case res := <-results:
+		if res.wait != nil {
+			c.runFailed(cr, res.wait)
+		}
+		if res.proto != nil {
+			// More interesting than the exit code
+			cr.Error = res.proto
+			if cr.ErrorType == "" {
+				cr.ErrorType = ErrorSession
+			}
+		}
+	case <-ctx.Done():
+		session.Close()
+		cr.interrupted("transfer", ctx.Err())
+	}
+}
+
+// scpAck reads an scp acknowledgement, returning any error the remote scp reports
+func scpAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		msg, _ := r.ReadString('\n')
+		return fmt.Errorf("%s", strings.TrimSpace(msg))
+	default:
+		return fmt.Errorf("unexpected scp response %q", b)
+	}
+}
+
+// parseScpHeader parses an scp "C" header line (e.g. "C0644 1234 filename\n")
+func parseScpHeader(line string) (mode os.FileMode, size int64, name string, err error) {
+	parts := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 3)
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "C") {
+		err = fmt.Errorf("unexpected scp header '%s'", strings.TrimSpace(line))
+		return
+	}
+
+	m, err := strconv.ParseUint(parts[0][1:], 8, 32)
+	if err != nil {
+		err = fmt.Errorf("bad mode in scp header '%s'", strings.TrimSpace(line))
+		return
+	}
+	mode = os.FileMode(m).Perm()
+
+	if size, err = strconv.ParseInt(parts[1], 10, 64); err != nil || size < 0 {
+		err = fmt.Errorf("bad size in scp header '%s'", strings.TrimSpace(line))
+		return
+	}
+
+	name = parts[2]
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		err = fmt.Errorf("bad file name in scp header '%s'", strings.TrimSpace(line))
+	}
+	return
+}
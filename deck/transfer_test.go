@@ -0,0 +1,85 @@
+//go:build !windows && !plan9
+
+package deck
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransfer_ParseScpHeader(t *testing.T) {
+	mode, size, name, err := parseScpHeader("C0640 1234 some file.txt\n")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+	if mode != 0640 || size != 1234 || name != "some file.txt" {
+		t.Errorf("Expected 0640, 1234, 'some file.txt', got %04o, %d, '%s'\n", mode, size, name)
+	}
+
+	for _, bad := range []string{"D0755 0 dir\n", "C0644 12\n", "Cnope 12 file\n", "C0644 -1 file\n", "C0644 12 ../../etc/passwd\n", "C0644 12 ..\n"} {
+		if _, _, _, err = parseScpHeader(bad); err == nil {
+			t.Errorf("Expected error for header '%s', got nil\n", bad)
+		}
+	}
+}
+
+func TestTransfer_PutGet(t *testing.T) {
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp is not available")
+	}
+
+	s := newTestSSHServer(t)
+	dir := t.TempDir()
+
+	local := filepath.Join(dir, "local.conf")
+	if err := ioutil.WriteFile(local, []byte("hello=world\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	remote := filepath.Join(dir, "remote.conf")
+	com := s.Command("")
+	cr := com.Put(context.Background(), local, remote, 0640)
+	if cr.Error != nil {
+		t.Fatalf("Unexpected PUT error: %s (%s)\n", cr.Error, cr.StderrString(false))
+	}
+	if stat, err := os.Stat(remote); err != nil || stat.Mode().Perm() != 0640 {
+		t.Errorf("Expected remote file with mode 0640, got %v %v\n", stat, err)
+	}
+
+	fetched := filepath.Join(dir, "fetched")
+	cr = com.Get(context.Background(), remote, fetched)
+	if cr.Error != nil {
+		t.Fatalf("Unexpected GET error: %s (%s)\n", cr.Error, cr.StderrString(false))
+	}
+
+	hname := fmt.Sprintf("%s_%s_%d", s.Host.Name, s.Host.Address, s.Host.Port)
+	got := filepath.Join(fetched, hname, "remote.conf")
+	buf, err := ioutil.ReadFile(got)
+	if err != nil || string(buf) != "hello=world\n" {
+		t.Errorf("Expected fetched file contents, got '%s' %v\n", buf, err)
+	}
+	if stat, err := os.Stat(got); err != nil || stat.Mode().Perm() != 0640 {
+		t.Errorf("Expected fetched file with mode 0640, got %v %v\n", stat, err)
+	}
+
+	cr = com.Get(context.Background(), filepath.Join(dir, "nope"), fetched)
+	if cr.Error == nil {
+		t.Error("Expected GET of missing file to fail, but it didn't")
+	}
+}
+
+func TestTransfer_WorkflowMalformed(t *testing.T) {
+	var w Workflow
+	com := Command{Host: Host{Name: "h"}}
+
+	for _, c := range []string{"PUT onlyone", "PUT a b 999", "GET a", "GET a b c"} {
+		if cr := w.handleTransfer(context.Background(), c, com); cr.Error == nil {
+			t.Errorf("Expected error for '%s', got nil\n", c)
+		}
+	}
+}
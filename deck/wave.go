@@ -0,0 +1,122 @@
+package deck
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WavePauseConfirm is the WavePause that asks before each wave
+const WavePauseConfirm = "confirm"
+
+// WavePause is how long to pause between waves, or whether to ask first
+type WavePause struct {
+	Confirm  bool
+	Duration time.Duration
+}
+
+// ParseWavePause parses a duration (e.g. "30s"), or "confirm", into a WavePause.
+// An empty string is no pause.
+func ParseWavePause(s string) (WavePause, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return WavePause{}, nil
+	} else if s == WavePauseConfirm {
+		return WavePause{Confirm: true}, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return WavePause{}, fmt.Errorf("'%s' is not a duration, or '%s'", s, WavePauseConfirm)
+	}
+	return WavePause{Duration: d}, nil
+}
+
+// Confirmations reads the answers to confirmation prompts, a line at a time, from a
+// single reader, so that nothing buffered for a later answer is lost
+type Confirmations struct {
+	in      *bufio.Reader
+	answers chan string
+	reading bool
+}
+
+// NewConfirmations returns Confirmations read from in
+func NewConfirmations(in io.Reader) *Confirmations {
+	return &Confirmations{
+		in:      bufio.NewReader(in),
+		answers: make(chan string, 1),
+	}
+}
+
+// answer returns the next answer, and true, or false if the context was done first.
+// Reading can't be interrupted, so a read still going then is the next one answered.
+func (c *Confirmations) answer(ctx context.Context) (string, bool) {
+	if !c.reading {
+		c.reading = true
+		go func() {
+			answer, _ := c.in.ReadString('\n')
+			c.answers <- answer
+		}()
+	}
+
+	select {
+	case answer := <-c.answers:
+		c.reading = false
+		return answer, true
+	case <-ctx.Done():
+		return "", false
+	}
+}
+
+// Wait pauses before the next wave, returning false if we shouldn't run it: Either
+// it wasn't confirmed, or the context was done while we were waiting.
+func (p WavePause) Wait(ctx context.Context, next int, in *Confirmations, out io.Writer) bool {
+	if p.Confirm {
+		fmt.Fprintf(out, "Continue with wave %d? [y/N] ", next)
+
+		answer, ok := in.answer(ctx)
+		if !ok {
+			fmt.Fprintln(out)
+			return false
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+
+	if p.Duration > 0 {
+		select {
+		case <-time.After(p.Duration):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// groupWaves groups the hosts by Wave, in ascending order, keeping them in order
+// within each wave. Hosts that aren't in a wave are returned separately.
+func groupWaves(hosts []Host) (waves [][]Host, none []Host) {
+	var inWaves []Host
+	for _, h := range hosts {
+		if h.Wave > 0 {
+			inWaves = append(inWaves, h)
+		} else {
+			none = append(none, h)
+		}
+	}
+	sort.SliceStable(inWaves, func(i, j int) bool {
+		return inWaves[i].Wave < inWaves[j].Wave
+	})
+
+	for i, h := range inWaves {
+		if i == 0 || h.Wave != inWaves[i-1].Wave {
+			waves = append(waves, nil)
+		}
+		waves[len(waves)-1] = append(waves[len(waves)-1], h)
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package deck
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWavePause_Parse(t *testing.T) {
+	if p, err := ParseWavePause(""); err != nil || p.Confirm || p.Duration != 0 {
+		t.Errorf("Expected no pause, got %+v, %v\n", p, err)
+	}
+	if p, err := ParseWavePause("confirm"); err != nil || !p.Confirm {
+		t.Errorf("Expected confirm, got %+v, %v\n", p, err)
+	}
+	if p, err := ParseWavePause("90s"); err != nil || p.Duration != 90*time.Second {
+		t.Errorf("Expected 90s, got %+v, %v\n", p, err)
+	}
+	for _, bad := range []string{"soon", "-1s", "Confirm"} {
+		if _, err := ParseWavePause(bad); err == nil {
+			t.Errorf("Expected error parsing '%s', got none\n", bad)
+		}
+	}
+}
+
+func TestWavePause_Wait(t *testing.T) {
+	var out bytes.Buffer
+	p := WavePause{Confirm: true}
+
+	if !p.Wait(context.Background(), 2, NewConfirmations(strings.NewReader("y\n")), &out) {
+		t.Error("Expected 'y' to continue")
+	}
+	if !strings.Contains(out.String(), "wave 2") {
+		t.Errorf("Expected prompt for wave 2, got '%s'\n", out.String())
+	}
+	if p.Wait(context.Background(), 2, NewConfirmations(strings.NewReader("\n")), &out) {
+		t.Error("Expected no answer to not continue")
+	}
+	if p.Wait(context.Background(), 2, NewConfirmations(strings.NewReader("")), &out) {
+		t.Error("Expected EOF to not continue")
+	}
+
+	p = WavePause{Duration: 10 * time.Millisecond}
+	if !p.Wait(context.Background(), 2, nil, &out) {
+		t.Error("Expected duration pause to continue")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p = WavePause{Duration: time.Hour}
+	if p.Wait(ctx, 2, nil, &out) {
+		t.Error("Expected cancelled pause to not continue")
+	}
+
+	// Nobody's answering
+	in, w := io.Pipe()
+	defer w.Close()
+	c := NewConfirmations(in)
+	p = WavePause{Confirm: true}
+	if p.Wait(ctx, 2, c, &out) {
+		t.Error("Expected cancelled confirmation to not continue")
+	}
+
+	// The read still going answers the next confirmation
+	go w.Write([]byte("y\n"))
+	if !p.Wait(context.Background(), 3, c, &out) {
+		t.Error("Expected a late 'y' to continue the next wave")
+	}
+}
+
+func TestWavePause_WaitAgain(t *testing.T) {
+	var out bytes.Buffer
+	p := WavePause{Confirm: true}
+	c := NewConfirmations(strings.NewReader("y\nyes\n"))
+
+	for wave := 2; wave <= 3; wave++ {
+		if !p.Wait(context.Background(), wave, c, &out) {
+			t.Errorf("Expected wave %d to be confirmed\n", wave)
+		}
+	}
+	if p.Wait(context.Background(), 4, c, &out) {
+		t.Error("Expected EOF to not continue wave 4")
+	}
+}
+
+func TestGroupWaves(t *testing.T) {
+	hosts := []Host{
+		{Name: "a", Wave: 2},
+		{Name: "b", Wave: 1},
+		{Name: "c"},
+		{Name: "d", Wave: 2},
+		{Name: "e", Wave: 10},
+		{Name: "f", Wave: 1},
+	}
+
+	waves, none := groupWaves(hosts)
+	expected := [][]string{{"b", "f"}, {"a", "d"}, {"e"}}
+	if len(waves) != len(expected) {
+		t.Fatalf("Expected %d waves, got %d\n", len(expected), len(waves))
+	}
+	for i, w := range waves {
+		var names []string
+		for _, h := range w {
+			names = append(names, h.Name)
+		}
+		if !stringArrayEquality(names, expected[i]) {
+			t.Errorf("Expected wave %d to be %v, got %v\n", i, expected[i], names)
+		}
+	}
+	if len(none) != 1 || none[0].Name != "c" {
+		t.Errorf("Expected 'c' to not be in a wave, got %v\n", none)
+	}
+}
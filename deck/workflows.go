@@ -0,0 +1,609 @@
+//go:build go1.4
+
+package deck
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dontUpdatePackages = "DONTUPDATEPACKAGES()"
+
+// hostVarRegex matches %host.varname% vars, which are expanded per-host
+var hostVarRegex = regexp.MustCompile(`%host\.([^%\s]+)%`)
+
+// chainName returns the name of the workflow a "%%anotherworkflowname" command
+// chains to, or "" if it isn't one
+func chainName(c string) string {
+	if !strings.HasPrefix(c, "%%") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(c, "%%"))
+}
+
+// WorkflowReturn is a structure returned after executing a workflow
+type WorkflowReturn struct {
+	Name           string
+	HostObj        Host
+	Completed      bool
+	CommandReturns []CommandReturn
+
+	// Step is the index of the last command started, or -1 if none were
+	Step int
+
+	// Start and End are when the workflow began, and finished. Connect, Session, and
+	// Exec are the totals of its CommandReturns'.
+	Start   time.Time
+	End     time.Time
+	Connect time.Duration
+	Session time.Duration
+	Exec    time.Duration
+}
+
+// Duration returns how long the workflow took
+func (wr *WorkflowReturn) Duration() time.Duration {
+	if wr.End.Before(wr.Start) {
+		return 0
+	}
+	return wr.End.Sub(wr.Start)
+}
+
+// Workflow is a structure to capture properties of an individual workflow
+type Workflow struct {
+	Filter         string
+	Name           string
+	Sudo           bool
+	MinTimeout     int
+	CommandTimeout int
+	Batch          string
+	MaxFail        string
+	MustChain      bool
+	Dnf            bool
+	Commands       []string
+	CommandBreaks  []bool
+	VarsRequired   []string
+	globals        map[string]string
+	vars           map[string]string
+	chained        map[string]*Workflow
+
+	// CommandSuccessCodes are non-zero exit codes, per command, that are not errors
+	CommandSuccessCodes [][]int
+	// CommandBreakCodes are non-zero exit codes, per command, that break the workflow.
+	// If a command has none, any non-zero exit code may break it.
+	CommandBreakCodes [][]int
+}
+
+// Merge another uninitialized Workflow into this one
+func (w *Workflow) Merge(other *Workflow) {
+
+	// Set filter if it's empty, otherwise AND it if it isn't there already
+	if w.Filter == "" && other.Filter != "" {
+		w.Filter = other.Filter
+	} else if other.Filter != "" {
+		if !strings.Contains(w.Filter, other.Filter) {
+			w.Filter = fmt.Sprintf("%s && %s", w.Filter, other.Filter)
+		}
+	}
+
+	// Sudo if we need it
+	if other.Sudo {
+		w.Sudo = true
+	}
+
+	// Dnf if we need it
+	if other.Dnf {
+		w.Dnf = true
+	}
+
+	// Ensure the max min
+	if other.MinTimeout > w.MinTimeout {
+		w.MinTimeout = other.MinTimeout
+	}
+
+	// and the max per-command
+	if other.CommandTimeout > w.CommandTimeout {
+		w.CommandTimeout = other.CommandTimeout
+	}
+
+	// Batch policy if we don't have one
+	if w.Batch == "" {
+		w.Batch = other.Batch
+	}
+	if w.MaxFail == "" {
+		w.MaxFail = other.MaxFail
+	}
+
+	// Pad the per-command arrays, so the other's stay aligned with its commands
+	for len(w.CommandBreaks) < len(w.Commands) && len(other.CommandBreaks) > 0 {
+		w.CommandBreaks = append(w.CommandBreaks, true)
+	}
+	for len(w.CommandSuccessCodes) < len(w.Commands) && len(other.CommandSuccessCodes) > 0 {
+		w.CommandSuccessCodes = append(w.CommandSuccessCodes, nil)
+	}
+	for len(w.CommandBreakCodes) < len(w.Commands) && len(other.CommandBreakCodes) > 0 {
+		w.CommandBreakCodes = append(w.CommandBreakCodes, nil)
+	}
+
+	// Append all the arrays
+	w.Commands = append(w.Commands, other.Commands...)
+	w.CommandBreaks = append(w.CommandBreaks, other.CommandBreaks...)
+	w.CommandSuccessCodes = append(w.CommandSuccessCodes, other.CommandSuccessCodes...)
+	w.CommandBreakCodes = append(w.CommandBreakCodes, other.CommandBreakCodes...)
+	w.VarsRequired = append(w.VarsRequired, other.VarsRequired...)
+
+}
+
+func (w *Workflow) varParse(s string) string {
+
+	// First check the global list
+	for k, v := range w.globals {
+		nk := "%" + k + "%"
+		s = strings.Replace(s, nk, v, -1)
+	}
+
+	// Then the workflow list
+	for k, v := range w.vars {
+		nk := "%" + k + "%"
+		s = strings.Replace(s, nk, v, -1)
+	}
+
+	return s
+}
+
+// hostVarParse expands any %host.varname% vars from the Command's Host's Vars. Unset
+// vars are left as they are.
+func (w *Workflow) hostVarParse(s string, com *Command) string {
+	return hostVarRegex.ReplaceAllStringFunc(s, func(m string) string {
+		name := hostVarRegex.FindStringSubmatch(m)[1]
+		if v, ok := com.Host.Var(name); ok {
+			return v
+		}
+		com.Runner.debugf("Host var '%s' is not set on %s\n", name, com.Host.Name)
+		return m
+	})
+}
+
+// Init initializes a workflow, returning the first error from a SET, if any
+func (w *Workflow) Init() error {
+	return w.InitVars(nil)
+}
+
+// InitVars initializes a workflow, as Init, expanding the specified global vars (e.g.
+// Runner.Vars) in its commands
+func (w *Workflow) InitVars(globals map[string]string) error {
+	return w.initWith(globals, nil)
+}
+
+// initWith initializes a workflow, starting with a copy of the specified vars
+func (w *Workflow) initWith(globals, vars map[string]string) (err error) {
+	w.globals = globals
+	w.vars = make(map[string]string)
+	w.chained = make(map[string]*Workflow)
+	for k, v := range vars {
+		w.vars[k] = v
+	}
+
+	// Prime the SET pump
+	for i, c := range w.Commands {
+		if strings.HasPrefix(c, "SET ") {
+			// SET %varname% "some string"
+			if serr := w.handleSet(c); serr != nil && err == nil {
+				err = fmt.Errorf("error during SET: %s", serr)
+			}
+		} else {
+			// Expand the vars, so we don't have to do it
+			// later, a billion times
+			w.Commands[i] = w.varParse(c)
+		}
+	}
+
+	return
+}
+
+// Exec executes a workflow against the supplied Host
+func (w *Workflow) Exec(com Command) WorkflowReturn {
+	return w.ExecContext(context.Background(), com)
+}
+
+// ExecContext executes a workflow against the supplied Host. Each command is
+// subject to the context, and to its timeout, if any: The Command's Timeout, overridden
+// by the workflow's CommandTimeout, overridden by a per-command TIMEOUT.
+func (w *Workflow) ExecContext(ctx context.Context, com Command) WorkflowReturn {
+	return w.ExecContextFrom(ctx, com, 0)
+}
+
+// ExecContextFrom executes a workflow against the supplied Host, as ExecContext, but
+// starting at the command at index start. SETs are still all evaluated. If there is no
+// command at start (e.g. the workflow has changed since), it starts from the beginning.
+func (w *Workflow) ExecContextFrom(ctx context.Context, com Command, start int) (wr WorkflowReturn) {
+
+	wr = WorkflowReturn{
+		Name:      w.Name,
+		HostObj:   com.Host,
+		Completed: false,
+		Step:      -1,
+		Start:     time.Now(),
+	}
+
+	com.Runner.debugf("Executing workflow %s\n", w.Name)
+
+	if start < 0 || (start > 0 && start >= len(w.Commands)) {
+		com.Runner.errorf("Workflow %s has no step %d to resume %s at, starting over\n", w.Name, start+1, com.Host.ID())
+		start = 0
+	}
+
+	// Tag our returns, unless a chained workflow already has, and total them up
+	defer func() {
+		wr.End = time.Now()
+		for i := range wr.CommandReturns {
+			cr := &wr.CommandReturns[i]
+			if cr.Workflow == "" {
+				cr.Workflow = w.Name
+			}
+			wr.Connect += cr.Connect
+			wr.Session += cr.Session
+			wr.Exec += cr.Exec
+		}
+	}()
+
+	// Chained workflows get the Command as we got it
+	chainCom := com
+
+	// Per-wf override for sudo
+	if w.Sudo {
+		com.Sudo = true
+	}
+
+	// Per-wf override for timeouts
+	if w.CommandTimeout > 0 {
+		com.Timeout = time.Duration(w.CommandTimeout) * time.Second
+	}
+	timeout := com.Timeout
+
+	for i, c := range w.Commands {
+		if i < start {
+			// Resuming
+			continue
+		}
+		com.Step = i
+		com.Timeout = timeout
+
+		if ctx.Err() != nil {
+			// We're done here
+			return
+		}
+		wr.Step = i
+
+		if strings.HasPrefix(c, "#") {
+			// Comment
+			continue
+		}
+
+		if strings.HasPrefix(c, "SET ") {
+			// SET %varname% "some string"
+			// Handled by Init()
+			continue
+		}
+
+		// Handle workflow special commands, in whichever order they're in. Make sure
+		// we're not quiet, unless told to be.
+		com.Quiet = false
+		for {
+			if strings.HasPrefix(c, "QUIET ") {
+				// Set quiet, and mangle the command
+				c = strings.TrimPrefix(c, "QUIET ")
+				com.Runner.debugf("Command quieted: '%s'\n", c)
+				com.Quiet = true
+			} else if strings.HasPrefix(c, "TIMEOUT ") {
+				// TIMEOUT DURATION command
+				var err error
+				c, com.Timeout, err = w.handleTimeout(c)
+				if err != nil {
+					com.Runner.errorf("Error during TIMEOUT: %s\n", err)
+					return
+				}
+			} else {
+				break
+			}
+		}
+
+		// Handle host vars
+		if strings.Contains(c, dontUpdatePackages) {
+			dnup, _ := com.Host.Var("dontupdatepackages")
+			com.Runner.debugf("%s: %s", dontUpdatePackages, dnup)
+			c = w.handleDNUP(c, dnup)
+		}
+		c = w.hostVarParse(c, &com)
+
+		if strings.HasPrefix(c, "%%") {
+			// %%anotherworkflowname
+			sub, ok := w.chained[chainName(c)]
+			if !ok {
+				com.Runner.errorf("Chained workflow '%s' does not exist, or was not initialized\n", chainName(c))
+				return
+			}
+
+			swr := sub.ExecContext(ctx, chainCom)
+			wr.CommandReturns = append(wr.CommandReturns, swr.CommandReturns...)
+			if !swr.Completed && (i >= len(w.CommandBreaks) || w.CommandBreaks[i]) {
+				// The chained workflow broke, and so do we
+				return
+			}
+
+		} else if strings.HasPrefix(c, "FOR ") {
+			// FOR list ACTION
+			crs, err := w.handleFor(ctx, c, com)
+			if len(crs) > 0 {
+				wr.CommandReturns = append(wr.CommandReturns, crs...)
+			}
+			if err != nil {
+				com.Runner.errorf("Error during FOR: %s\n", err)
+				return
+			}
+		} else if strings.HasPrefix(c, "PUT ") || strings.HasPrefix(c, "GET ") {
+			// PUT local remote [mode]
+			// GET remote localdir
+			res := w.handleTransfer(ctx, c, com)
+			w.checkSuccess(i, &res)
+			wr.CommandReturns = append(wr.CommandReturns, res)
+			if w.breaks(i, &res) {
+				return
+			}
+		} else if strings.HasPrefix(c, "SLEEP ") {
+			// SLEEP DURATION
+			c = strings.TrimPrefix(c, "SLEEP ")
+			com.Runner.debugf("SLEEP for %s\n", c)
+			err := w.handleSleep(ctx, c)
+			if err != nil {
+				com.Runner.errorf("Error during SLEEP: %s\n", err)
+				// non-fatal
+			}
+		} else {
+			// Regular command
+			com.Cmd = c
+			res := com.ExecContext(ctx)
+			w.checkSuccess(i, &res)
+			wr.CommandReturns = append(wr.CommandReturns, res)
+			if w.breaks(i, &res) {
+				return
+			}
+		}
+	}
+	// POST: No errors
+
+	wr.Completed = true
+	return
+}
+
+// checkSuccess clears the error of a CommandReturn from the command at index i, if
+// its exit code is one of the command's CommandSuccessCodes
+func (w *Workflow) checkSuccess(i int, cr *CommandReturn) {
+	if cr.ErrorType != ErrorExit || cr.ExitSignal != "" || i >= len(w.CommandSuccessCodes) {
+		return
+	}
+
+	for _, code := range w.CommandSuccessCodes[i] {
+		if code == cr.ExitCode {
+			cr.Error = nil
+			cr.ErrorType = ""
+			return
+		}
+	}
+}
+
+// breaks returns true if the CommandReturn from the command at index i should break
+// the workflow
+func (w *Workflow) breaks(i int, cr *CommandReturn) bool {
+	if cr.Error == nil {
+		return false
+	} else if i < len(w.CommandBreaks) && !w.CommandBreaks[i] {
+		// We are using CommandBreaks, and it's false
+		return false
+	} else if cr.ErrorType == ErrorExit && cr.ExitSignal == "" && i < len(w.CommandBreakCodes) && len(w.CommandBreakCodes[i]) > 0 {
+		// Only specific exit codes break this command
+		for _, code := range w.CommandBreakCodes[i] {
+			if code == cr.ExitCode {
+				return true
+			}
+		}
+		return false
+	}
+	// We have a valid error, and either we're not using CommandBreaks (assume breaks)
+	//	or we are using CommandBreaks, and it's true
+	return true
+}
+
+func (w *Workflow) handleFor(ctx context.Context, c string, com Command) ([]CommandReturn, error) {
+
+	var crs []CommandReturn
+
+	cparts := strings.Fields(c)
+	if len(cparts) < 3 {
+		// Hmmm, malformated FOR
+		return crs, fmt.Errorf("'FOR list ACTION' statement incomplete: '%s'", c)
+	}
+
+	var list []string
+
+	// Set up our list
+	if cparts[1] == "needs-restarting" {
+		// Do that voodoo that you do, for special command "needs-restarting"
+		if w.Dnf {
+			com.Cmd = "dnf needs-restarting"
+		} else {
+			com.Cmd = "needs-restarting"
+		}
+
+		listRes := com.ExecContext(ctx)
+		crs = append(crs, listRes)
+		if listRes.Error != nil {
+			// We have a valid error, and must break, as we'll have
+			//  an invalid list to operate on
+			return crs, fmt.Errorf("needs-restarting (%s) on host %s failed: %s", com.Cmd, com.Host.Name, listRes.Error)
+		}
+		list = needsRestartingMangler(listRes.StdoutStrings(true), makeList([]string{w.globals["dontrestart-processes"]}))
+	} else {
+		// Treat the middle of cparts as actual list items
+		list = makeList(cparts[1 : len(cparts)-1])
+	}
+
+	// Handle our ACTIONs
+	action := strings.ToLower(cparts[len(cparts)-1])
+	if action == "restart" ||
+		action == "start" ||
+		action == "stop" ||
+		action == "status" {
+		// Service operation requested.
+
+		serviceResults := make(chan CommandReturn, 10)
+		serviceList(ctx, action, list, serviceResults, com)
+
+		for li := 0; li < len(list); li++ {
+			res := <-serviceResults
+			crs = append(crs, res)
+		}
+	}
+
+	return crs, nil
+
+}
+
+func (w *Workflow) handleSet(c string) (err error) {
+
+	cparts := strings.Fields(c)
+
+	if len(cparts) < 3 {
+		// Hmmm, malformated SET
+		return fmt.Errorf("error SET statement incomplete: '%s'", c)
+	}
+
+	vname := strings.Trim(cparts[1], "%") // nuke the lead/trail percents from the varname
+
+	vvalue := strings.Join(cparts[2:], " ") // concatenate any end parts
+	vvalue = strings.Trim(vvalue, "\"")     // nuke lead/trail dub-quotes
+	vvalue = strings.Trim(vvalue, "'")      // nuke lead/trail sing-quotes
+	vvalue = w.varParse(vvalue)             // Check it for vars
+
+	if strings.Contains(vvalue, "S3(") {
+		// We need a tokened S3 URL
+		vvalue, err = w.handleS3(vvalue, w.globals["awsaccess_key"], w.globals["awsaccess_secretkey"])
+	} else if strings.Contains(vvalue, "RAND(") {
+		// We need a random string
+		vvalue, err = w.handleRand(vvalue)
+	}
+
+	if err == nil {
+		w.vars[vname] = vvalue
+	}
+
+	return
+}
+
+func (w *Workflow) handleS3(vvalue, accessKey, secretKey string) (string, error) {
+
+	// Confirm we actually have the bits set
+	if _, ok := w.globals["awsaccess_key"]; !ok {
+		return "", fmt.Errorf("no AWS access key set, but S3() called")
+	} else if _, ok := w.globals["awsaccess_secretkey"]; !ok {
+		return "", fmt.Errorf("no AWS secret key set, but S3() called")
+	}
+
+	re := regexp.MustCompile(`^(.*)S3\((.*)\)(.*)$`)
+	rparts := re.FindStringSubmatch(vvalue)
+	if rparts == nil {
+		return "", fmt.Errorf("error processing S3(s): '%s'", vvalue)
+	}
+
+	s3u := s3UrlToParts(rparts[2])
+	url := generateS3Url(s3u.Bucket, s3u.Path,
+		accessKey, secretKey, "", 60)
+
+	return rparts[1] + url + rparts[3], nil
+}
+
+func (w *Workflow) handleRand(vvalue string) (string, error) {
+
+	re := regexp.MustCompile(`^(.*)RAND\(([0-9]+)\)(.*)$`)
+	rparts := re.FindStringSubmatch(vvalue)
+	if rparts == nil {
+		return "", fmt.Errorf("error processing RAND(n): '%s'", vvalue)
+	}
+
+	n, err := strconv.Atoi(rparts[2])
+	if err != nil {
+		return "", fmt.Errorf("problem using '%s' as a number", rparts[2])
+	}
+	return rparts[1] + randString(n) + rparts[3], nil
+}
+
+func (w *Workflow) handleSleep(ctx context.Context, vvalue string) error {
+
+	sleepFor, err := time.ParseDuration(vvalue)
+	if err == nil {
+		select {
+		case <-time.After(sleepFor):
+		case <-ctx.Done():
+		}
+	}
+	return err
+
+}
+
+// handleTransfer handles "PUT local remote [mode]" and "GET remote localdir"
+func (w *Workflow) handleTransfer(ctx context.Context, c string, com Command) CommandReturn {
+
+	cparts := strings.Fields(c)
+
+	switch {
+	case cparts[0] == "PUT" && (len(cparts) == 3 || len(cparts) == 4):
+		var mode os.FileMode
+		if len(cparts) == 4 {
+			m, err := strconv.ParseUint(cparts[3], 8, 32)
+			if err != nil {
+				cr := com.transferReturn(c)
+				cr.Error = fmt.Errorf("PUT mode '%s' is not an octal file mode", cparts[3])
+				return cr
+			}
+			mode = os.FileMode(m).Perm()
+		}
+		return com.Put(ctx, cparts[1], cparts[2], mode)
+	case cparts[0] == "GET" && len(cparts) == 3:
+		return com.Get(ctx, cparts[1], cparts[2])
+	}
+
+	// Hmmm, malformated PUT or GET
+	cr := com.transferReturn(c)
+	cr.Error = fmt.Errorf("'PUT local remote [mode]' or 'GET remote localdir' statement malformed: '%s'", c)
+	return cr
+}
+
+// handleTimeout splits a "TIMEOUT duration command" into the command, and the duration
+func (w *Workflow) handleTimeout(c string) (string, time.Duration, error) {
+
+	cparts := strings.SplitN(c, " ", 3)
+	if len(cparts) < 3 {
+		// Hmmm, malformated TIMEOUT
+		return "", 0, fmt.Errorf("'TIMEOUT duration command' statement incomplete: '%s'", c)
+	}
+
+	timeout, err := time.ParseDuration(cparts[1])
+	if err != nil {
+		return "", 0, err
+	}
+
+	return strings.TrimSpace(cparts[2]), timeout, nil
+}
+
+func (w *Workflow) handleDNUP(vvalue, dnup string) string {
+	if dnup == "" {
+		// nothing
+		return strings.Replace(vvalue, dontUpdatePackages, dnup, -1)
+	}
+
+	return strings.Replace(vvalue, dontUpdatePackages, fmt.Sprintf("--exclude=%s", dnup), -1)
+}
@@ -0,0 +1,214 @@
+//go:build !windows && !plan9
+
+package deck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkflow_Breaks(t *testing.T) {
+	w := Workflow{
+		Commands:            []string{"grep x y", "false", "something", "other"},
+		CommandBreaks:       []bool{true, false, true, true},
+		CommandSuccessCodes: [][]int{{1}},
+		CommandBreakCodes:   [][]int{nil, nil, {2, 3}},
+	}
+
+	exit := func(code int) *CommandReturn {
+		return &CommandReturn{Error: fmt.Errorf("exit %d", code), ErrorType: ErrorExit, ExitCode: code}
+	}
+
+	cr := exit(1)
+	w.checkSuccess(0, cr)
+	if cr.Error != nil || w.breaks(0, cr) {
+		t.Errorf("Expected exit 1 to be a success, got %v\n", cr.Error)
+	}
+
+	cr = exit(2)
+	w.checkSuccess(0, cr)
+	if cr.Error == nil || !w.breaks(0, cr) {
+		t.Error("Expected exit 2 to break")
+	}
+
+	if w.breaks(1, exit(1)) {
+		t.Error("Expected CommandBreaks false to not break")
+	}
+
+	if w.breaks(2, exit(1)) {
+		t.Error("Expected exit 1 not in CommandBreakCodes to not break")
+	}
+	if !w.breaks(2, exit(3)) {
+		t.Error("Expected exit 3 in CommandBreakCodes to break")
+	}
+	if !w.breaks(2, &CommandReturn{Error: fmt.Errorf("nope"), ErrorType: ErrorConnection}) {
+		t.Error("Expected connection error to break regardless of CommandBreakCodes")
+	}
+
+	if !w.breaks(3, exit(1)) {
+		t.Error("Expected default to break")
+	}
+}
+
+func TestWorkflow_MergeAlignment(t *testing.T) {
+	w := Workflow{Commands: []string{"one", "two"}}
+	o := Workflow{
+		Commands:          []string{"three"},
+		CommandBreaks:     []bool{false},
+		CommandBreakCodes: [][]int{{2}},
+	}
+
+	w.Merge(&o)
+
+	if len(w.CommandBreaks) != 3 || !w.CommandBreaks[0] || w.CommandBreaks[2] {
+		t.Errorf("Expected CommandBreaks aligned to commands, got %v\n", w.CommandBreaks)
+	}
+	if len(w.CommandBreakCodes) != 3 || len(w.CommandBreakCodes[2]) != 1 {
+		t.Errorf("Expected CommandBreakCodes aligned to commands, got %v\n", w.CommandBreakCodes)
+	}
+}
+
+func TestWorkflow_HandleTimeout(t *testing.T) {
+	var w Workflow
+
+	c, d, err := w.handleTimeout("TIMEOUT 90s yum update -y")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+	if c != "yum update -y" || d != 90*time.Second {
+		t.Errorf("Expected 'yum update -y' and 90s, got '%s' and %s\n", c, d)
+	}
+
+	if _, _, err = w.handleTimeout("TIMEOUT 90s"); err == nil {
+		t.Error("Expected error for incomplete TIMEOUT, got nil")
+	}
+	if _, _, err = w.handleTimeout("TIMEOUT soon yum update -y"); err == nil {
+		t.Error("Expected error for bad TIMEOUT duration, got nil")
+	}
+}
+
+func TestWorkflow_StepTimeout(t *testing.T) {
+	s := newTestSSHServer(t)
+
+	w := Workflow{
+		Name:          "timeouts",
+		Commands:      []string{"TIMEOUT 200ms sleep 10", "echo after", "TIMEOUT 5s QUIET echo quiet", "QUIET TIMEOUT 5s echo quieter"},
+		CommandBreaks: []bool{false, true, true, true},
+	}
+	w.Init()
+
+	wr := w.Exec(s.Command(""))
+	if !wr.Completed || len(wr.CommandReturns) != 4 {
+		t.Fatalf("Expected completed workflow with 4 returns, got %v with %d\n", wr.Completed, len(wr.CommandReturns))
+	}
+	if !wr.CommandReturns[0].TimedOut || wr.CommandReturns[0].Step != 0 {
+		t.Errorf("Expected step 0 to time out, got %+v\n", wr.CommandReturns[0])
+	}
+	if wr.CommandReturns[1].TimedOut || wr.CommandReturns[1].StdoutString(false) != "after\n" {
+		t.Errorf("Expected step 1 to run normally, got %+v\n", wr.CommandReturns[1])
+	}
+	for _, cr := range wr.CommandReturns[2:] {
+		if !cr.Quiet || strings.HasPrefix(cr.Command, "QUIET") || strings.HasPrefix(cr.Command, "TIMEOUT") || cr.Error != nil {
+			t.Errorf("Expected a quiet command without its prefixes, got '%s' (quiet %v): %v\n", cr.Command, cr.Quiet, cr.Error)
+		}
+	}
+	var exec time.Duration
+	for _, cr := range wr.CommandReturns {
+		exec += cr.Exec
+	}
+	if wr.Exec != exec || wr.Exec <= 0 {
+		t.Errorf("Expected the workflow's exec time to be its commands', %s, got %s\n", exec, wr.Exec)
+	}
+	if wr.Duration() < wr.Exec {
+		t.Errorf("Expected the workflow to take at least %s, got %s\n", wr.Exec, wr.Duration())
+	}
+}
+
+func TestWorkflow_Chain(t *testing.T) {
+	s := newTestSSHServer(t)
+
+	conf := Config{
+		Workflows: []Workflow{
+			{Name: "top", Commands: []string{"echo top", "%%bottom", "echo after"}},
+			{Name: "bottom", Commands: []string{"echo bottom", "false", "echo never"}},
+		},
+	}
+	conf.InitWorkflow(0, nil)
+
+	wr := conf.Workflows[0].Exec(s.Command(""))
+	if wr.Completed {
+		t.Error("Expected broken chained workflow to break the parent")
+	}
+	if len(wr.CommandReturns) != 3 {
+		t.Fatalf("Expected 3 returns, got %d\n", len(wr.CommandReturns))
+	}
+	for i, name := range []string{"top", "bottom", "bottom"} {
+		if wr.CommandReturns[i].Workflow != name {
+			t.Errorf("Expected return %d tagged '%s', got '%s'\n", i, name, wr.CommandReturns[i].Workflow)
+		}
+	}
+
+	// Don't break on the chained workflow
+	conf.Workflows[0].CommandBreaks = []bool{true, false, true}
+	wr = conf.Workflows[0].Exec(s.Command(""))
+	if !wr.Completed || len(wr.CommandReturns) != 4 {
+		t.Errorf("Expected completed workflow with 4 returns, got %v with %d\n", wr.Completed, len(wr.CommandReturns))
+	}
+}
+
+func TestWorkflow_HostVars(t *testing.T) {
+	s := newTestSSHServer(t)
+
+	w := Workflow{
+		Name:     "hostvars",
+		Commands: []string{"echo %host.datadir% %host.nope%", "echo yum update DONTUPDATEPACKAGES()"},
+	}
+	w.Init()
+
+	com := s.Command("")
+	com.Host.Vars = map[string]string{"datadir": "/data", "dontupdatepackages": "kernel*"}
+
+	wr := w.Exec(com)
+	if !wr.Completed || len(wr.CommandReturns) != 2 {
+		t.Fatalf("Expected completed workflow with 2 returns, got %v with %d\n", wr.Completed, len(wr.CommandReturns))
+	}
+	if out := wr.CommandReturns[0].StdoutString(false); out != "/data %host.nope%\n" {
+		t.Errorf("Expected host var expanded, got '%s'\n", out)
+	}
+	if out := wr.CommandReturns[1].StdoutString(false); out != "yum update --exclude=kernel*\n" {
+		t.Errorf("Expected DONTUPDATEPACKAGES() expanded, got '%s'\n", out)
+	}
+}
+
+func TestWorkflow_Resume(t *testing.T) {
+	s := newTestSSHServer(t)
+
+	w := Workflow{
+		Name:     "resume",
+		Commands: []string{"SET %WORD% resumed", "echo first", "false", "echo %WORD%"},
+	}
+	w.Init()
+
+	wr := w.Exec(s.Command(""))
+	if wr.Completed || wr.Step != 2 {
+		t.Fatalf("Expected workflow to break at step 2, got %v at %d\n", wr.Completed, wr.Step)
+	}
+
+	w.Commands[2] = "true"
+	wr = w.ExecContextFrom(context.Background(), s.Command(""), wr.Step)
+	if !wr.Completed || wr.Step != 3 || len(wr.CommandReturns) != 2 {
+		t.Fatalf("Expected resumed workflow to complete with 2 returns, got %v at %d with %d\n", wr.Completed, wr.Step, len(wr.CommandReturns))
+	}
+	if out := wr.CommandReturns[1].StdoutString(false); out != "resumed\n" {
+		t.Errorf("Expected SET var on resume, got '%s'\n", out)
+	}
+
+	// The workflow got shorter
+	wr = w.ExecContextFrom(context.Background(), s.Command(""), len(w.Commands))
+	if !wr.Completed || len(wr.CommandReturns) != 3 {
+		t.Errorf("Expected a step past the end to start over, with 3 returns, got %v with %d\n", wr.Completed, len(wr.CommandReturns))
+	}
+}